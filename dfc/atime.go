@@ -0,0 +1,359 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Access tracking: the target records access time and count of each cached
+// cloud object in memory, and periodically flushes the per-mountpath index to
+// the mountpath's atimeIndexFile. Eviction (see lru.go) uses the index instead of
+// stat-ing every file, and does not depend on the filesystem atime (noatime, relatime).
+
+const (
+	atimeIndexFile        = "/.dfc.atime"
+	atimeFlushTimeDefault = time.Minute
+)
+
+type accessinfo struct {
+	Atime int64 // unix nano
+	Count int64
+	Size  int64
+}
+
+type atimeindex struct {
+	objs  map[string]*accessinfo // mountpath-relative names, i.e. bucket/objname
+	dirty bool
+}
+
+type atimerunner struct {
+	namedrunner
+	sync.Mutex
+	mpaths map[string]*atimeindex
+	usage  map[string]int64 // bytes per bucket, see quota.go
+	pins   pinstats
+	chstop chan struct{}
+}
+
+// running totals of the pinned objects, see pinnedsize
+type pinstats struct {
+	files, bytes int64
+	counted      bool
+	gen          int64     // pinmap generation as of the last recount
+	nextexpiry   time.Time // recount once the next pin expires
+}
+
+func (r *atimerunner) run() error {
+	r.Lock()
+	r.init()
+	r.Unlock()
+	r.chstop = make(chan struct{})
+	flushtime := ctx.config.Cache.AtimeFlushTime
+	if flushtime == 0 {
+		flushtime = atimeFlushTimeDefault
+	}
+	glog.Infof("Starting %s", r.name)
+	ticker := time.NewTicker(flushtime)
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.chstop:
+			ticker.Stop()
+			r.flush()
+			return nil
+		}
+	}
+}
+
+func (r *atimerunner) stop(err error) {
+	glog.Infof("Stopping %s, err: %v", r.name, err)
+	close(r.chstop)
+}
+
+// NOTE: the caller must hold the lock
+func (r *atimerunner) init() {
+	if r.mpaths == nil {
+		r.mpaths = make(map[string]*atimeindex)
+		r.usage = make(map[string]int64)
+	}
+}
+
+// loads the existing indexes; must be called once the mountpaths are known
+func (r *atimerunner) load() {
+	r.Lock()
+	defer r.Unlock()
+	r.init()
+	for mpath := range ctx.mountpaths {
+		file, err := os.Open(mpath + atimeIndexFile)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				glog.Errorf("Failed to open access index %q, err: %v", mpath+atimeIndexFile, err)
+			}
+			continue
+		}
+		idx := &atimeindex{objs: make(map[string]*accessinfo)}
+		if err = gob.NewDecoder(file).Decode(&idx.objs); err != nil {
+			glog.Errorf("Failed to load access index %q, err: %v", mpath+atimeIndexFile, err)
+		} else {
+			r.mpaths[mpath] = idx
+			for rel, ai := range idx.objs {
+				r.usage[bucketof(rel)] += ai.Size
+			}
+			r.pins.counted = false
+			glog.Infof("Loaded access index %q: %d objects", mpath+atimeIndexFile, len(idx.objs))
+		}
+		file.Close()
+	}
+}
+
+// indexes the cached objects that are not tracked yet (e.g., the index was lost or not flushed
+// before a restart), so that the bucket usage (see quota.go) accounts for all of them
+func (r *atimerunner) seedall() {
+	cont := func() error { return nil }
+	filter := func(bucket string) bool {
+		_, bucket = parsebucket(bucket) // see cachebucket
+		return !ctx.bmd.islocal(bucket)
+	}
+	visit := func(fqn string, stat *syscall.Stat_t) error {
+		atime := time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+		mtime := time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec))
+		if mtime.After(atime) {
+			atime = mtime
+		}
+		r.seed(fqn, atime, stat.Size)
+		return nil
+	}
+	for mpath := range ctx.mountpaths {
+		err := filepath.Walk(mpath, func(fqn string, osfi os.FileInfo, err error) error {
+			if err != nil && os.IsNotExist(err) { // removed while walking
+				return nil
+			}
+			return walkobj(fqn, osfi, err, cont, filter, visit)
+		})
+		if err != nil {
+			glog.Errorf("Failed to index mpath %q, err: %v", mpath, err)
+		}
+	}
+	glog.Infoln("Indexed the cached objects")
+}
+
+// copies the dirty indexes under the lock, and encodes and writes them outside of it
+// so that touch (i.e., every GET) does not wait on the disk
+func (r *atimerunner) flush() {
+	r.Lock()
+	dirty := make(map[string]map[string]*accessinfo)
+	for mpath, idx := range r.mpaths {
+		if !idx.dirty {
+			continue
+		}
+		objs := make(map[string]*accessinfo, len(idx.objs))
+		for rel, ai := range idx.objs {
+			copied := *ai
+			objs[rel] = &copied
+		}
+		dirty[mpath] = objs
+		idx.dirty = false
+	}
+	r.Unlock()
+	for mpath, objs := range dirty {
+		if err := writeatimeindex(mpath+atimeIndexFile, objs); err != nil {
+			glog.Errorf("Failed to flush access index %q, err: %v", mpath+atimeIndexFile, err)
+			r.Lock()
+			if idx := r.mpaths[mpath]; idx != nil {
+				idx.dirty = true // retry next time
+			}
+			r.Unlock()
+		}
+	}
+}
+
+func writeatimeindex(fqn string, objs map[string]*accessinfo) error {
+	tmpfqn := fqn + ".tmp"
+	file, err := os.Create(tmpfqn)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(objs)
+	file.Close()
+	if err == nil {
+		err = os.Rename(tmpfqn, fqn)
+	}
+	if err != nil {
+		os.Remove(tmpfqn)
+	}
+	return err
+}
+
+// NOTE: the caller must hold the lock
+func (r *atimerunner) locate(fqn string, create bool) (idx *atimeindex, rel string) {
+	mountpath := fqn2mountpath(fqn)
+	if mountpath == nil {
+		return
+	}
+	rel, err := filepath.Rel(mountpath.Path, fqn)
+	if err != nil || strings.HasPrefix(rel, "../") {
+		return nil, ""
+	}
+	r.init()
+	idx = r.mpaths[mountpath.Path]
+	if idx == nil && create {
+		idx = &atimeindex{objs: make(map[string]*accessinfo)}
+		r.mpaths[mountpath.Path] = idx
+	}
+	return
+}
+
+// records an access
+func (r *atimerunner) touch(fqn string, size int64) {
+	r.Lock()
+	defer r.Unlock()
+	idx, rel := r.locate(fqn, true)
+	if idx == nil {
+		return
+	}
+	ai, ok := idx.objs[rel]
+	if !ok {
+		ai = &accessinfo{}
+		idx.objs[rel] = ai
+	}
+	r.usage[bucketof(rel)] += size - ai.Size
+	if pinnedobj(rel) {
+		if !ok {
+			r.pins.files++
+		}
+		r.pins.bytes += size - ai.Size
+	}
+	ai.Atime, ai.Size = time.Now().UnixNano(), size
+	ai.Count++
+	idx.dirty = true
+}
+
+// adds an object discovered by the filesystem walk, unless already tracked
+func (r *atimerunner) seed(fqn string, usetime time.Time, size int64) {
+	r.Lock()
+	defer r.Unlock()
+	idx, rel := r.locate(fqn, true)
+	if idx == nil {
+		return
+	}
+	if _, ok := idx.objs[rel]; ok {
+		return
+	}
+	idx.objs[rel] = &accessinfo{Atime: usetime.UnixNano(), Size: size}
+	r.usage[bucketof(rel)] += size
+	if pinnedobj(rel) {
+		r.pins.files++
+		r.pins.bytes += size
+	}
+	idx.dirty = true
+}
+
+func (r *atimerunner) remove(fqn string) {
+	r.Lock()
+	defer r.Unlock()
+	idx, rel := r.locate(fqn, false)
+	if idx == nil {
+		return
+	}
+	if ai, ok := idx.objs[rel]; ok {
+		r.usage[bucketof(rel)] -= ai.Size
+		if pinnedobj(rel) {
+			r.pins.files--
+			r.pins.bytes -= ai.Size
+		}
+		delete(idx.objs, rel)
+		idx.dirty = true
+	}
+}
+
+func (r *atimerunner) pinnedsize() (files, bytes int64) {
+	r.Lock()
+	defer r.Unlock()
+	gen, nextexpiry := pinned.state()
+	now := time.Now()
+	if !r.pins.counted || r.pins.gen != gen || (!r.pins.nextexpiry.IsZero() && now.After(r.pins.nextexpiry)) {
+		r.pins = pinstats{counted: true, gen: gen, nextexpiry: nextexpiry}
+		for _, idx := range r.mpaths {
+			for rel, ai := range idx.objs {
+				if pinnedobj(rel) {
+					r.pins.files++
+					r.pins.bytes += ai.Size
+				}
+			}
+		}
+	}
+	return r.pins.files, r.pins.bytes
+}
+
+// total size of the bucket's cached objects on this target
+func (r *atimerunner) bucketusage(bucket string) int64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.usage[bucket]
+}
+
+// all indexed objects of a given bucket
+func (r *atimerunner) bucketsnapshot(bucket string) []*fileinfo {
+	r.Lock()
+	defer r.Unlock()
+	var fis []*fileinfo
+	for mpath, idx := range r.mpaths {
+		for rel, ai := range idx.objs {
+			if bucketof(rel) != bucket {
+				continue
+			}
+			fis = append(fis, &fileinfo{
+				fqn:     mpath + "/" + rel,
+				objkey:  rel,
+				usetime: time.Unix(0, ai.Atime),
+				size:    ai.Size,
+				count:   ai.Count,
+			})
+		}
+	}
+	return fis
+}
+
+func bucketof(objkey string) string {
+	return strings.SplitN(objkey, "/", 2)[0]
+}
+
+// returns nil if the mountpath is not indexed yet
+func (r *atimerunner) snapshot(mpath string) []*fileinfo {
+	r.Lock()
+	defer r.Unlock()
+	idx := r.mpaths[mpath]
+	if idx == nil {
+		return nil
+	}
+	fis := make([]*fileinfo, 0, len(idx.objs))
+	for rel, ai := range idx.objs {
+		fis = append(fis, &fileinfo{
+			fqn:     mpath + "/" + rel,
+			objkey:  rel,
+			usetime: time.Unix(0, ai.Atime),
+			size:    ai.Size,
+			count:   ai.Count,
+		})
+	}
+	return fis
+}
+
+// NOTE: a no-op when running without the target, e.g. in *_test
+func atimetouch(fqn string, size int64) {
+	if r := getatimerunner(); r != nil {
+		r.touch(fqn, size)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Access index: track, flush to disk, reload, and snapshot for eviction.
+//
+// Example run:
+// 	go test -v -run=atime
+//
+package dfc
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_atime(t *testing.T) {
+	mpath, err := ioutil.TempDir("", "atime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mpath)
+	saved := ctx.mountpaths
+	ctx.mountpaths = map[string]*mountPath{mpath: {Path: mpath}}
+	defer func() { ctx.mountpaths = saved }()
+
+	r := &atimerunner{}
+	r.touch(mpath+"/bucket/a", 100)
+	r.touch(mpath+"/bucket/a", 100)
+	r.touch(mpath+"/bucket/b", 200)
+	r.remove(mpath + "/bucket/b")
+	r.flush()
+
+	r = &atimerunner{}
+	r.load()
+	fis := r.snapshot(mpath)
+	if len(fis) != 1 {
+		t.Fatalf("Expected 1 indexed object, got %d", len(fis))
+	}
+	fi := fis[0]
+	if fi.objkey != "bucket/a" || fi.fqn != mpath+"/bucket/a" || fi.count != 2 || fi.size != 100 {
+		t.Errorf("Unexpected index entry %+v", *fi)
+	}
+	if r.snapshot("/nonexistent") != nil {
+		t.Error("Expected no index for an unknown mountpath")
+	}
+}
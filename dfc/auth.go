@@ -0,0 +1,296 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Authentication and authorization: with Auth.Enabled every request must carry a token - a JWT
+// signed (HS256) with the cluster-wide Auth.Secret - either in the "Authorization: Bearer <token>"
+// header or in the "token" query parameter. The token's role is RoleAdmin (all operations) or
+// RoleUser (data access only: the objects and the bucket listings in /v1/files, and GET /v1/buckets;
+// the bucket actions, e.g. evict and prefetch, require RoleAdmin; plus the read-only stats: GET /metrics
+// and the authstats queries of the cluster and the daemons). In addition, the bucket properties
+// Readers and Writers, if not empty, limit access to the bucket's objects to the listed users
+// (token subjects). The proxy checks the access before redirecting; the redirect carries a
+// short-lived token for the target. The daemons call each other with admin tokens of their own.
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+const (
+	authquery       = "token"
+	authredirectttl = time.Minute // the token the proxy passes to the target with a redirect
+	authdaemonttl   = time.Hour   // intra-cluster
+)
+
+// JWT claims
+type Authclaims struct {
+	Subject string `json:"sub"`
+	Role    string `json:"role"`
+	Expires int64  `json:"exp,omitempty"` // unix time (0: never)
+}
+
+type authkey struct{} // request context
+
+// GetMsg queries permitted to RoleUser: stats only, no configuration and no bucket metadata
+var authstats = map[string]bool{GetStats: true, GetLRU: true, GetXactions: true, GetHistory: true, GetCapacity: true}
+
+var daemontoken struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+//===========================
+//
+// tokens
+//
+//===========================
+
+func maketoken(secret string, claims *Authclaims) string {
+	payload, err := json.Marshal(claims)
+	assert(err == nil, err)
+	s := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	return s + "." + signtoken(secret, s)
+}
+
+// the token signed with the secret from the given config, see the -token flag
+func configtoken(conffile, subject, role string, ttl time.Duration) (string, error) {
+	if role != RoleAdmin && role != RoleUser {
+		return "", fmt.Errorf("Invalid role %q (expecting %s or %s)", role, RoleAdmin, RoleUser)
+	}
+	config := &dfconfig{}
+	if err := readconfig(conffile, config); err != nil {
+		return "", err
+	}
+	if config.Auth.Secret == "" {
+		return "", fmt.Errorf("No auth secret in config %q", conffile)
+	}
+	claims := &Authclaims{Subject: subject, Role: role}
+	if ttl > 0 {
+		claims.Expires = time.Now().Add(ttl).Unix()
+	}
+	return maketoken(config.Auth.Secret, claims), nil
+}
+
+func signtoken(secret, s string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func parsetoken(secret, token string) (*Authclaims, error) {
+	if token == "" {
+		return nil, errors.New("Missing token")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(raw, &header) != nil || header.Alg != "HS256" {
+		return nil, errors.New("Invalid token header (expecting HS256)")
+	}
+	if !hmac.Equal([]byte(signtoken(secret, parts[0]+"."+parts[1])), []byte(parts[2])) {
+		return nil, errors.New("Invalid token signature")
+	}
+	claims := &Authclaims{}
+	if raw, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(raw, claims) != nil {
+		return nil, errors.New("Malformed token claims")
+	}
+	if claims.Expires != 0 && time.Now().Unix() >= claims.Expires {
+		return nil, errors.New("Token expired")
+	}
+	if claims.Role != RoleAdmin && claims.Role != RoleUser {
+		return nil, fmt.Errorf("Invalid role %q", claims.Role)
+	}
+	return claims, nil
+}
+
+func requesttoken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return r.URL.Query().Get(authquery)
+}
+
+// the authenticated user, if any
+func requestclaims(r *http.Request) *Authclaims {
+	claims, _ := r.Context().Value(authkey{}).(*Authclaims)
+	return claims
+}
+
+//===========================
+//
+// authorization
+//
+//===========================
+
+func authorize(claims *Authclaims, r *http.Request) error {
+	if claims.Role == RoleAdmin {
+		return nil
+	}
+	apitems := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method == http.MethodGet {
+		switch {
+		case apitems[0] == Rmetrics:
+			return nil
+		case len(apitems) == 2 && apitems[0] == Rversion && (apitems[1] == Rcluster || apitems[1] == Rdaemon):
+			if what := getmsgwhat(r); authstats[what] {
+				return nil
+			}
+		}
+	}
+	if len(apitems) >= 3 && apitems[0] == Rversion {
+		switch {
+		case apitems[1] == Rfiles && (len(apitems) > 3 || r.Method == http.MethodGet):
+			// objects, and the bucket listing; bucket actions (e.g., evict, prefetch) require admin
+			_, bucket := parsebucket(apitems[2])
+			return bucketaccess(claims.Subject, bucket, r.Method == http.MethodGet)
+		case apitems[1] == Rbuckets && r.Method == http.MethodGet:
+			_, bucket := parsebucket(apitems[2])
+			return bucketaccess(claims.Subject, bucket, true)
+		}
+	}
+	return fmt.Errorf("%s %s requires the %s role", r.Method, r.URL.Path, RoleAdmin)
+}
+
+// peeks at the GetMsg in the body and puts the body back for the handler
+func getmsgwhat(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var msg GetMsg
+	if err != nil || json.Unmarshal(body, &msg) != nil {
+		return ""
+	}
+	return msg.What
+}
+
+func bucketaccess(subject, bucket string, read bool) error {
+	props := ctx.bmd.getprops(bucket)
+	acl, access := props.Writers, "write"
+	if read {
+		acl, access = props.Readers, "read"
+	}
+	if len(acl) == 0 || contains(acl, subject) {
+		return nil
+	}
+	return fmt.Errorf("User %q is not permitted to %s bucket %s", subject, access, bucket)
+}
+
+// wraps all handlers, see registerhdlr
+func (r *httprunner) authhdlr(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !ctx.config.Auth.Enabled {
+			handler(w, req)
+			return
+		}
+		claims, err := parsetoken(ctx.config.Auth.Secret, requesttoken(req))
+		status := http.StatusUnauthorized
+		if err == nil {
+			err, status = authorize(claims, req), http.StatusForbidden
+		}
+		if err != nil {
+			s := errmsgRestApi(err.Error(), req)
+			glog.Errorln(s)
+			http.Error(w, s, status)
+			r.statsif.add("numerr", 1)
+			return
+		}
+		handler(w, req.WithContext(context.WithValue(req.Context(), authkey{}, claims)))
+	}
+}
+
+// the redirect URL carries a short-lived token on behalf of the user, see targeturl
+func redirecttoken(r *http.Request) string {
+	claims := requestclaims(r)
+	if claims == nil {
+		return ""
+	}
+	return maketoken(ctx.config.Auth.Secret, &Authclaims{
+		Subject: claims.Subject,
+		Role:    claims.Role,
+		Expires: time.Now().Add(authredirectttl).Unix(),
+	})
+}
+
+//===========================
+//
+// intra-cluster
+//
+//===========================
+
+// adds the daemon's token to the requests made by the intra-cluster clients, see newhttpclient
+type authtransport struct {
+	base http.RoundTripper
+}
+
+func (t *authtransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !ctx.config.Auth.Enabled || req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	// NOTE: RoundTrip must not modify the request
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		clone.Header[k] = v
+	}
+	clone.Header.Set("Authorization", "Bearer "+getdaemontoken())
+	return t.base.RoundTrip(clone)
+}
+
+func getdaemontoken() string {
+	daemontoken.Lock()
+	defer daemontoken.Unlock()
+	if now := time.Now(); daemontoken.token == "" || now.After(daemontoken.expires.Add(-authdaemonttl/2)) {
+		daemontoken.expires = now.Add(authdaemonttl)
+		daemontoken.token = maketoken(ctx.config.Auth.Secret, &Authclaims{
+			Subject: "daemon:" + daemonid(),
+			Role:    RoleAdmin,
+			Expires: daemontoken.expires.Unix(),
+		})
+	}
+	return daemontoken.token
+}
+
+// appends the token to the URL
+func withtoken(rawurl, token string) string {
+	if token == "" {
+		return rawurl
+	}
+	sep := "?"
+	if strings.Contains(rawurl, "?") {
+		sep = "&"
+	}
+	return rawurl + sep + authquery + "=" + url.QueryEscape(token)
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Auth: tokens, roles, per-bucket ACLs, the -token flag, the redirect token, intra-cluster tokens.
+//
+// Example run:
+// 	go test -v -run=auth
+//
+package dfc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_auth(t *testing.T) {
+	savedauth, savedbmd := ctx.config.Auth, ctx.bmd
+	defer func() { ctx.config.Auth, ctx.bmd = savedauth, savedbmd }()
+	ctx.config.Auth = authconfig{Enabled: true, Secret: "secret"}
+	ctx.bmd = newbucketmd()
+	ctx.bmd.setprops("private", &BucketProps{Readers: []string{"alice"}, Writers: []string{"alice"}})
+
+	// tokens
+	expires := time.Now().Add(time.Hour).Unix()
+	token := maketoken("secret", &Authclaims{Subject: "alice", Role: RoleUser, Expires: expires})
+	if claims, err := parsetoken("secret", token); err != nil || claims.Subject != "alice" || claims.Expires != expires {
+		t.Errorf("Unexpected %+v, err: %v", claims, err)
+	}
+	for _, bad := range []string{
+		"",
+		"abc",
+		maketoken("other", &Authclaims{Subject: "alice", Role: RoleUser}),
+		maketoken("secret", &Authclaims{Subject: "alice", Role: RoleUser, Expires: time.Now().Add(-time.Second).Unix()}),
+		maketoken("secret", &Authclaims{Subject: "alice", Role: "root"}),
+		token[:strings.LastIndex(token, ".")] + ".AAAA",
+	} {
+		if _, err := parsetoken("secret", bad); err == nil {
+			t.Errorf("Expected an error parsing %q", bad)
+		}
+	}
+
+	// roles and ACLs
+	p := &httprunner{statsif: newstatsregistry()}
+	var served *http.Request
+	hdlr := p.authhdlr(func(w http.ResponseWriter, r *http.Request) { served = r })
+	do := func(method, path, subject, role string) int {
+		served = nil
+		r := httptest.NewRequest(method, path, nil)
+		if subject != "" {
+			r.Header.Set("Authorization", "Bearer "+maketoken("secret", &Authclaims{Subject: subject, Role: role}))
+		}
+		w := httptest.NewRecorder()
+		hdlr(w, r)
+		return w.Code
+	}
+	for _, tc := range []struct {
+		method, path, subject, role string
+		status                      int
+	}{
+		{http.MethodGet, "/v1/files/bucket/obj", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/files/bucket/obj", "bob", RoleUser, http.StatusOK},
+		{http.MethodPut, "/v1/files/bucket/obj", "bob", RoleUser, http.StatusOK},
+		{http.MethodGet, "/v1/buckets/bucket", "bob", RoleUser, http.StatusOK},
+		{http.MethodPut, "/v1/buckets/bucket", "bob", RoleUser, http.StatusForbidden},
+		{http.MethodPut, "/v1/cluster", "bob", RoleUser, http.StatusForbidden},
+		{http.MethodGet, "/v1/daemon", "bob", RoleUser, http.StatusForbidden},
+		{http.MethodGet, "/metrics", "bob", RoleUser, http.StatusOK},
+		{http.MethodPut, "/v1/cluster", "root", RoleAdmin, http.StatusOK},
+		{http.MethodGet, "/v1/files/private/obj", "bob", RoleUser, http.StatusForbidden},
+		{http.MethodGet, "/v1/files/gs:private/obj", "bob", RoleUser, http.StatusForbidden},
+		{http.MethodGet, "/v1/buckets/gs:private", "bob", RoleUser, http.StatusForbidden},
+		{http.MethodDelete, "/v1/files/private/obj", "bob", RoleUser, http.StatusForbidden},
+		{http.MethodGet, "/v1/files/private/obj", "alice", RoleUser, http.StatusOK},
+		{http.MethodPut, "/v1/files/private/obj", "alice", RoleUser, http.StatusOK},
+		{http.MethodGet, "/v1/files/private/obj", "root", RoleAdmin, http.StatusOK},
+		{http.MethodGet, "/v1/files/bucket", "bob", RoleUser, http.StatusOK},
+		{http.MethodGet, "/v1/files/private", "bob", RoleUser, http.StatusForbidden},
+		{http.MethodPut, "/v1/files/bucket", "bob", RoleUser, http.StatusForbidden},
+		{http.MethodPut, "/v1/files/private", "alice", RoleUser, http.StatusForbidden},
+		{http.MethodPut, "/v1/files/bucket", "root", RoleAdmin, http.StatusOK},
+	} {
+		if status := do(tc.method, tc.path, tc.subject, tc.role); status != tc.status {
+			t.Errorf("%s %s by %s (%s): expected %d, got %d", tc.method, tc.path, tc.subject, tc.role, tc.status, status)
+		}
+	}
+
+	// the read-only stats: the handler gets the query intact
+	for _, tc := range []struct {
+		path, what string
+		status     int
+	}{
+		{"/v1/cluster", GetStats, http.StatusOK},
+		{"/v1/daemon", GetHistory, http.StatusOK},
+		{"/v1/cluster", GetConfig, http.StatusForbidden},
+		{"/v1/daemon", GetBucketMD, http.StatusForbidden},
+	} {
+		served = nil
+		body := `{"what": "` + tc.what + `"}`
+		r := httptest.NewRequest(http.MethodGet, tc.path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+maketoken("secret", &Authclaims{Subject: "bob", Role: RoleUser}))
+		w := httptest.NewRecorder()
+		hdlr(w, r)
+		if w.Code != tc.status {
+			t.Errorf("GET %s %s by bob (%s): expected %d, got %d", tc.path, tc.what, RoleUser, tc.status, w.Code)
+		}
+		if served != nil {
+			if b, _ := ioutil.ReadAll(served.Body); string(b) != body {
+				t.Errorf("GET %s: the handler got %q, expected %q", tc.path, b, body)
+			}
+		}
+	}
+
+	// the -token flag
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conffile, nosecret := filepath.Join(dir, "dfc.json"), filepath.Join(dir, "nosecret.json")
+	if err = ioutil.WriteFile(conffile, []byte(`{"auth": {"enabled": true, "secret": "secret"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(nosecret, []byte(`{"auth": {"enabled": true}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := configtoken(conffile, "alice", RoleAdmin, time.Hour); err != nil {
+		t.Error(err)
+	} else if claims, err := parsetoken("secret", token); err != nil || claims.Role != RoleAdmin || claims.Expires == 0 {
+		t.Errorf("Unexpected %+v, err: %v", claims, err)
+	}
+	for _, c := range []struct{ conffile, role string }{
+		{conffile, "root"}, {nosecret, RoleUser}, {filepath.Join(dir, "missing.json"), RoleUser},
+	} {
+		if _, err := configtoken(c.conffile, "alice", c.role, 0); err == nil {
+			t.Errorf("Expected an error for role %q, config %q", c.role, c.conffile)
+		}
+	}
+
+	// the redirect token and the authenticated client
+	do(http.MethodGet, "/v1/files/private/obj?x=1", "alice", RoleUser)
+	if served == nil || clientid(served) != "alice" {
+		t.Fatalf("Expected the request to be served on behalf of alice")
+	}
+	redirect, err := url.Parse(targeturl(&ServerInfo{DirectURL: "http://127.0.0.1:8081"}, served))
+	if err != nil || redirect.Path != "/v1/files/private/obj" || redirect.Query().Get("x") != "1" {
+		t.Fatalf("Unexpected redirect URL %v, err: %v", redirect, err)
+	}
+	claims, err := parsetoken("secret", redirect.Query().Get(authquery))
+	if err != nil || claims.Subject != "alice" || claims.Role != RoleUser || claims.Expires > time.Now().Add(authredirectttl).Unix() {
+		t.Errorf("Unexpected redirect token %+v, err: %v", claims, err)
+	}
+
+	// intra-cluster
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, err := parsetoken("secret", requesttoken(r)); err != nil || claims.Role != RoleAdmin {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	client, err := newhttpclient(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Get(server.URL)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Errorf("Intra-cluster call failed: %v, err: %v", response, err)
+	}
+	if response != nil {
+		response.Body.Close()
+	}
+}
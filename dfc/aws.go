@@ -28,14 +28,30 @@ func (obj *awsif) listbucket(w http.ResponseWriter, bucket string) error {
 	sess := createsession()
 	svc := s3.New(sess)
 	params := &s3.ListObjectsInput{Bucket: aws.String(bucket)}
-	resp, err := svc.ListObjects(params)
-	if err != nil {
-		return webinterror(w, err.Error())
+	// S3 returns up to 1000 keys at a time; the entire listing is collected before
+	// responding, so that a failure in the middle is not mistaken for a shorter list
+	keys := make([]string, 0, 1000)
+	for {
+		resp, err := svc.ListObjects(params)
+		if err != nil {
+			return webinterror(w, err.Error())
+		}
+		for _, key := range resp.Contents {
+			keys = append(keys, *key.Key)
+		}
+		if resp.IsTruncated == nil || !*resp.IsTruncated || len(resp.Contents) == 0 {
+			break
+		}
+		// NextMarker is returned only when listing with a delimiter
+		if resp.NextMarker != nil {
+			params.Marker = resp.NextMarker
+		} else {
+			params.Marker = resp.Contents[len(resp.Contents)-1].Key
+		}
 	}
+	glog.Infof("bucket %s: %d keys", bucket, len(keys))
 	// TODO: reimplement in JSON
-	for _, key := range resp.Contents {
-		glog.Infof("bucket = %s key = %s", bucket, *key.Key)
-		keystr := fmt.Sprintf("%s", *key.Key)
+	for _, keystr := range keys {
 		fmt.Fprintln(w, keystr)
 	}
 	return nil
@@ -84,5 +100,7 @@ func downloadobject(w http.ResponseWriter, downloader *s3manager.Downloader,
 	}
 	stats := getstorstats()
 	stats.add("bytesloaded", bytes)
+	stats.addcloud(amazoncloud, "bytesloaded", bytes)
+	bucketstats.add(cachebucket(amazoncloud, bucket), "bytesloaded", bytes)
 	return file, nil
 }
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Per-bucket and (optionally, see dfconfig.ClientStats) per-client counters on the targets:
+// GET '{"what": "stats", "param1": bucket}' returns the breakdown for the given bucket only;
+// the proxy aggregates the breakdowns cluster-wide (see httpclugetstats).
+
+// breakdown counters
+var breakdowncounters = []string{"numget", "numcoldget", "bytesserved", "bytesloaded", "bytesevicted", "numerr"}
+
+const (
+	breakdownmaxkeys = 1024    // max number of buckets or clients tracked
+	breakdownother   = "other" // the rest
+)
+
+type breakdown struct {
+	sync.RWMutex
+	keys map[string]map[string]*int64 // bucket or client => counter name => value
+}
+
+var (
+	bucketstats = &breakdown{}
+	clientstats = &breakdown{}
+)
+
+func (b *breakdown) add(key, name string, val int64) {
+	b.RLock()
+	counters, ok := b.keys[key]
+	b.RUnlock()
+	if !ok {
+		b.Lock()
+		if b.keys == nil {
+			b.keys = make(map[string]map[string]*int64)
+		}
+		if counters, ok = b.keys[key]; !ok {
+			if len(b.keys) >= breakdownmaxkeys {
+				key = breakdownother
+			}
+			if counters, ok = b.keys[key]; !ok {
+				counters = make(map[string]*int64, len(breakdowncounters))
+				for _, n := range breakdowncounters {
+					counters[n] = new(int64)
+				}
+				b.keys[key] = counters
+			}
+		}
+		b.Unlock()
+	}
+	if v, ok := counters[name]; ok {
+		atomic.AddInt64(v, val)
+	}
+}
+
+// the non-zero counters; all keys if filter is empty
+func (b *breakdown) snapshot(filter string) map[string]map[string]int64 {
+	b.RLock()
+	defer b.RUnlock()
+	out := make(map[string]map[string]int64, len(b.keys))
+	for key, counters := range b.keys {
+		if filter != "" && key != filter {
+			continue
+		}
+		c := make(map[string]int64, len(counters))
+		for name, v := range counters {
+			if val := atomic.LoadInt64(v); val != 0 {
+				c[name] = val
+			}
+		}
+		out[key] = c
+	}
+	return out
+}
+
+// sums up the breakdowns, e.g. from all targets
+func addbreakdown(dst, src map[string]map[string]int64) {
+	for key, counters := range src {
+		d, ok := dst[key]
+		if !ok {
+			d = make(map[string]int64, len(counters))
+			dst[key] = d
+		}
+		for name, val := range counters {
+			d[name] += val
+		}
+	}
+}
+
+// counts a client request against the bucket and, if enabled, the client
+func bstats(r *http.Request, bucket, name string, val int64) {
+	bucketstats.add(bucket, name, val)
+	if ctx.config.ClientStats && r != nil {
+		clientstats.add(clientid(r), name, val)
+	}
+}
+
+// the authenticated user (see auth.go) or the client's IP address
+func clientid(r *http.Request) string {
+	if claims := requestclaims(r); claims != nil {
+		return claims.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
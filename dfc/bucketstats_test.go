@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Per-bucket and per-client breakdown: counters, bucket filter, the cap on the number of keys,
+// cluster-wide aggregation, the bucket names the target accounts by.
+//
+// Example run:
+// 	go test -v -run=breakdown
+//
+package dfc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_breakdown(t *testing.T) {
+	b := &breakdown{}
+	b.add("b1", "numget", 2)
+	b.add("b1", "bytesserved", 100)
+	b.add("b2", "numcoldget", 1)
+	b.add("b2", "nosuchcounter", 1)
+
+	all := b.snapshot("")
+	if len(all) != 2 || all["b1"]["numget"] != 2 || all["b1"]["bytesserved"] != 100 || all["b2"]["numcoldget"] != 1 {
+		t.Fatalf("Unexpected snapshot %+v", all)
+	}
+	if _, ok := all["b2"]["nosuchcounter"]; ok {
+		t.Errorf("Unexpected counter in %+v", all["b2"])
+	}
+	if _, ok := all["b1"]["numerr"]; ok {
+		t.Errorf("Zero counters are not expected in %+v", all["b1"])
+	}
+	if one := b.snapshot("b2"); len(one) != 1 || one["b2"]["numcoldget"] != 1 {
+		t.Errorf("Unexpected filtered snapshot %+v", one)
+	}
+
+	// the cap
+	for i := 0; i < breakdownmaxkeys+10; i++ {
+		b.add(fmt.Sprintf("key%d", i), "numget", 1)
+	}
+	all = b.snapshot("")
+	if len(all) != breakdownmaxkeys+1 || all[breakdownother]["numget"] != 12 {
+		t.Errorf("Expected %d keys and 12 gets in %q, got %d and %d",
+			breakdownmaxkeys+1, breakdownother, len(all), all[breakdownother]["numget"])
+	}
+	b.add("b1", "numget", 1) // existing keys continue to count
+	if n := b.snapshot("b1")["b1"]["numget"]; n != 3 {
+		t.Errorf("Expected 3 gets, got %d", n)
+	}
+
+	// aggregation
+	total := make(map[string]map[string]int64)
+	addbreakdown(total, map[string]map[string]int64{"b1": {"numget": 1}, "b2": {"numerr": 1}})
+	addbreakdown(total, map[string]map[string]int64{"b1": {"numget": 2, "bytesserved": 10}})
+	if total["b1"]["numget"] != 3 || total["b1"]["bytesserved"] != 10 || total["b2"]["numerr"] != 1 {
+		t.Errorf("Unexpected aggregate %+v", total)
+	}
+
+	r := &http.Request{RemoteAddr: "10.0.0.1:5000"}
+	if id := clientid(r); id != "10.0.0.1" {
+		t.Errorf("Unexpected client ID %q", id)
+	}
+}
+
+func Test_breakdownbucket(t *testing.T) {
+	savedbmd, savedprovider, savedstats := ctx.bmd, ctx.config.CloudProvider, bucketstats
+	defer func() { ctx.bmd, ctx.config.CloudProvider, bucketstats = savedbmd, savedprovider, savedstats }()
+	ctx.bmd = newbucketmd()
+	ctx.config.CloudProvider = amazoncloud
+	bucketstats = &breakdown{}
+
+	// the same-named buckets of the default and the other provider are accounted separately
+	tr := &targetrunner{}
+	tr.statsif = newstatsregistry()
+	for _, bucket := range []string{"cb", "gs:cb"} {
+		w := httptest.NewRecorder()
+		tr.httpfilget(w, httptest.NewRequest(http.MethodGet, "/"+Rversion+"/"+Rfiles+"/"+bucket+"/obj", nil))
+	}
+	all := bucketstats.snapshot("")
+	if len(all) != 2 || all["cb"]["numget"] != 1 || all["gs:cb"]["numget"] != 1 || all["gs:cb"]["numerr"] != 1 {
+		t.Errorf("Unexpected per-bucket stats %+v", all)
+	}
+}
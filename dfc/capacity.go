@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Capacity and cache content: GET '{"what": "capacity"}' /v1/daemon returns the target's
+// filesystem capacity (per mountpath and per filesystem), the number and size of cached objects
+// per bucket (objects stored on their HRW mountpath, see hrwMpath; the local mirror copies are
+// counted separately, see mirror.go), the pinned data, and the age distribution of the cached data
+// (time since the object was written, including local copies). /v1/cluster returns the same for
+// each target along with the cluster-wide totals. NOTE: walks all mountpaths - not meant to be
+// polled often.
+
+const capacitytimeout = 5 * time.Minute // max time for a target to walk its mountpaths
+
+// age distribution: the upper bounds
+var capacityages = []struct {
+	label string
+	age   time.Duration
+}{
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"older", 0},
+}
+
+type Capacity struct {
+	Total       Fscapacity                `json:"total"`                 // all filesystems
+	Mountpaths  map[string]*Fscapacity    `json:"mountpaths,omitempty"`  // target only
+	Filesystems map[string]*Fscapacity    `json:"filesystems,omitempty"` // by device, target only
+	Buckets     map[string]*Cachecapacity `json:"buckets"`
+	Pinned      Cachecapacity             `json:"pinned"`
+	Ages        map[string]*Cachecapacity `json:"ages"`              // see capacityages
+	Targets     map[string]*Capacity      `json:"targets,omitempty"` // cluster only
+	Errors      map[string]string         `json:"errors,omitempty"`  // ditto
+}
+
+type Fscapacity struct {
+	Total   int64 `json:"total"` // bytes
+	Used    int64 `json:"used"`
+	Avail   int64 `json:"avail"` // available to unprivileged users
+	Usedpct int   `json:"usedpct"`
+}
+
+type Cachecapacity struct {
+	Objects   int64 `json:"objects"`
+	Bytes     int64 `json:"bytes"`
+	Copies    int64 `json:"copies,omitempty"` // local mirror copies, buckets only
+	Copybytes int64 `json:"copybytes,omitempty"`
+}
+
+func newcapacity() *Capacity {
+	c := &Capacity{
+		Buckets: make(map[string]*Cachecapacity),
+		Ages:    make(map[string]*Cachecapacity, len(capacityages)),
+	}
+	for _, a := range capacityages {
+		c.Ages[a.label] = &Cachecapacity{}
+	}
+	return c
+}
+
+func (f *Fscapacity) add(other *Fscapacity) {
+	f.Total += other.Total
+	f.Used += other.Used
+	f.Avail += other.Avail
+	if f.Total > 0 {
+		f.Usedpct = int((f.Total - f.Avail) * 100 / f.Total)
+	}
+}
+
+func (c *Cachecapacity) add(objects, bytes int64) {
+	c.Objects += objects
+	c.Bytes += bytes
+}
+
+func (c *Cachecapacity) addcopies(copies, bytes int64) {
+	c.Copies += copies
+	c.Copybytes += bytes
+}
+
+func (c *Capacity) addobj(bucket string, size int64, age time.Duration, iscopy bool) {
+	b, ok := c.Buckets[bucket]
+	if !ok {
+		b = &Cachecapacity{}
+		c.Buckets[bucket] = b
+	}
+	if iscopy {
+		b.addcopies(1, size)
+	} else {
+		b.add(1, size)
+	}
+	for _, a := range capacityages {
+		if a.age == 0 || age < a.age {
+			c.Ages[a.label].add(1, size)
+			break
+		}
+	}
+}
+
+// sums up the target's capacity into the cluster's
+func (c *Capacity) addtarget(t *Capacity) {
+	c.Total.add(&t.Total)
+	for bucket, b := range t.Buckets {
+		if _, ok := c.Buckets[bucket]; !ok {
+			c.Buckets[bucket] = &Cachecapacity{}
+		}
+		c.Buckets[bucket].add(b.Objects, b.Bytes)
+		c.Buckets[bucket].addcopies(b.Copies, b.Copybytes)
+	}
+	c.Pinned.add(t.Pinned.Objects, t.Pinned.Bytes)
+	for label, a := range t.Ages {
+		if _, ok := c.Ages[label]; !ok {
+			c.Ages[label] = &Cachecapacity{}
+		}
+		c.Ages[label].add(a.Objects, a.Bytes)
+	}
+}
+
+func fscapacity(mpath string) (*Fscapacity, error) {
+	statfs := syscall.Statfs_t{}
+	if err := syscall.Statfs(mpath, &statfs); err != nil {
+		return nil, err
+	}
+	bsize := int64(statfs.Bsize)
+	f := &Fscapacity{
+		Total: int64(statfs.Blocks) * bsize,
+		Used:  int64(statfs.Blocks-statfs.Bfree) * bsize,
+		Avail: int64(statfs.Bavail) * bsize,
+	}
+	if statfs.Blocks > 0 {
+		f.Usedpct = int((statfs.Blocks - statfs.Bavail) * 100 / statfs.Blocks)
+	}
+	return f, nil
+}
+
+//===========================
+//
+// target
+//
+//===========================
+
+func getcapacity() *Capacity {
+	c := newcapacity()
+	c.Mountpaths = make(map[string]*Fscapacity, len(ctx.mountpaths))
+	c.Filesystems = make(map[string]*Fscapacity)
+	fsmap := make(map[syscall.Fsid]bool, len(ctx.mountpaths))
+	for _, mountpath := range ctx.mountpaths {
+		f, err := fscapacity(mountpath.Path)
+		if err != nil {
+			glog.Errorf("Failed to statfs mp %q, err: %v", mountpath.Path, err)
+			continue
+		}
+		c.Mountpaths[mountpath.Path] = f
+		if fsmap[mountpath.Fsid] { // the same filesystem
+			continue
+		}
+		fsmap[mountpath.Fsid] = true
+		device := mountpath.Device
+		if device == "" {
+			device = mountpath.Path
+		}
+		c.Filesystems[device] = f
+		c.Total.add(f)
+	}
+	now := time.Now()
+	cont := func() error { return nil }
+	filter := func(bucket string) bool { return true }
+	for mpath := range ctx.mountpaths {
+		visit := func(fqn string, stat *syscall.Stat_t) error {
+			rel, err := filepath.Rel(mpath, fqn)
+			if err != nil {
+				return nil
+			}
+			split := strings.SplitN(rel, "/", 2)
+			if len(split) < 2 {
+				return nil
+			}
+			mtime := time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec))
+			c.addobj(split[0], stat.Size, now.Sub(mtime), hrwMpath(rel) != mpath)
+			return nil
+		}
+		err := filepath.Walk(mpath, func(fqn string, osfi os.FileInfo, err error) error {
+			if err != nil && os.IsNotExist(err) { // removed while walking
+				return nil
+			}
+			return walkobj(fqn, osfi, err, cont, filter, visit)
+		})
+		if err != nil {
+			glog.Errorf("Failed to traverse mpath %q, err: %v", mpath, err)
+		}
+	}
+	c.Pinned.Objects, c.Pinned.Bytes = pinnedsize()
+	return c
+}
+
+//===========================
+//
+// proxy
+//
+//===========================
+
+func (p *proxyrunner) getcapacity(msg *GetMsg) *Capacity {
+	msgbytes, err := json.Marshal(msg)
+	assert(err == nil, err)
+	c := newcapacity()
+	c.Targets, c.Errors = make(map[string]*Capacity), make(map[string]string)
+	for sid, res := range p.fanout(http.MethodGet, Rdaemon, msgbytes, capacitytimeout) {
+		if res.err != nil {
+			c.Errors[sid] = res.err.Error()
+			continue
+		}
+		t := &Capacity{}
+		if err := json.Unmarshal(res.outjson, t); err != nil {
+			c.Errors[sid] = err.Error()
+			continue
+		}
+		c.Targets[sid] = t
+		c.addtarget(t)
+	}
+	return c
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Capacity: filesystem capacity, cached objects and mirror copies per bucket, the age distribution,
+// cluster totals.
+//
+// Example run:
+// 	go test -v -run=capacity
+//
+package dfc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_capacity(t *testing.T) {
+	mpath, err := ioutil.TempDir("", "capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mpath)
+	savedmp := ctx.mountpaths
+	defer func() { ctx.mountpaths = savedmp }()
+	ctx.mountpaths = map[string]*mountPath{mpath: {Path: mpath}}
+
+	files := map[string]int{
+		"b1/fresh":       100,
+		"b1/dir/old":     200,
+		"b2/ancient":     300,
+		"b2/.ec/slice":   400, // system files are not counted
+		".dfc.atime":     500,
+		"b2/dir/.hidden": 600,
+	}
+	for name, size := range files {
+		fqn := filepath.Join(mpath, name)
+		if err = os.MkdirAll(filepath.Dir(fqn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(fqn, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, age := range map[string]time.Duration{"b1/dir/old": 2 * time.Hour, "b2/ancient": 100 * 24 * time.Hour} {
+		mtime := time.Now().Add(-age)
+		if err = os.Chtimes(filepath.Join(mpath, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := getcapacity()
+	if f := c.Mountpaths[mpath]; f == nil || f.Total <= 0 || f.Avail > f.Total || c.Total.Total != f.Total {
+		t.Errorf("Unexpected capacity %+v, total %+v", f, c.Total)
+	}
+	if len(c.Filesystems) != 1 {
+		t.Errorf("Expected one filesystem, got %+v", c.Filesystems)
+	}
+	if len(c.Buckets) != 2 || *c.Buckets["b1"] != (Cachecapacity{Objects: 2, Bytes: 300}) ||
+		*c.Buckets["b2"] != (Cachecapacity{Objects: 1, Bytes: 300}) {
+		t.Errorf("Unexpected buckets %+v %+v", c.Buckets["b1"], c.Buckets["b2"])
+	}
+	for label, expected := range map[string]Cachecapacity{"1h": {Objects: 1, Bytes: 100}, "1d": {Objects: 1, Bytes: 200},
+		"7d": {}, "older": {Objects: 1, Bytes: 300}} {
+		if *c.Ages[label] != expected {
+			t.Errorf("Age %s: expected %+v, got %+v", label, expected, *c.Ages[label])
+		}
+	}
+
+	// cluster
+	cluster := newcapacity()
+	cluster.addtarget(c)
+	cluster.addtarget(c)
+	if cluster.Total.Total != 2*c.Total.Total || cluster.Total.Usedpct != c.Total.Usedpct {
+		t.Errorf("Unexpected cluster total %+v vs %+v", cluster.Total, c.Total)
+	}
+	if *cluster.Buckets["b1"] != (Cachecapacity{Objects: 4, Bytes: 600}) ||
+		*cluster.Ages["older"] != (Cachecapacity{Objects: 2, Bytes: 600}) {
+		t.Errorf("Unexpected cluster buckets %+v, ages %+v", cluster.Buckets["b1"], cluster.Ages["older"])
+	}
+}
+
+func Test_capacitycopies(t *testing.T) {
+	savedmp, savedbmd := ctx.mountpaths, ctx.bmd
+	defer func() { ctx.mountpaths, ctx.bmd = savedmp, savedbmd }()
+	dir := testmountpaths(t, 3) // see mirror_test.go
+	defer os.RemoveAll(dir)
+	ctx.bmd = newbucketmd()
+	ctx.bmd.setprops("bucket", &BucketProps{Mirror: 2})
+
+	for _, objname := range []string{"a", "b", "c"} {
+		fqns := mirrorfqns("bucket", objname)
+		if len(fqns) != 2 {
+			t.Fatalf("Expected 2 copies, got %v", fqns)
+		}
+		for _, fqn := range fqns {
+			if err := os.MkdirAll(filepath.Dir(fqn), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(fqn, make([]byte, 10), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	c := getcapacity()
+	if b := c.Buckets["bucket"]; b == nil || *b != (Cachecapacity{Objects: 3, Bytes: 30, Copies: 3, Copybytes: 30}) {
+		t.Errorf("Expected 3 objects and 3 copies, got %+v", b)
+	}
+	if a := c.Ages["1h"]; a.Objects != 6 {
+		t.Errorf("Expected the age distribution to include the copies, got %+v", a)
+	}
+	cluster := newcapacity()
+	cluster.addtarget(c)
+	if b := cluster.Buckets["bucket"]; b.Copies != 3 || b.Copybytes != 30 {
+		t.Errorf("Unexpected cluster bucket %+v", b)
+	}
+}
+
+func Test_capacitycluster(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := newcapacity()
+		c.addobj("bucket", 10, time.Minute, false)
+		json.NewEncoder(w).Encode(c)
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	savedsmap := ctx.smap
+	defer func() { ctx.smap = savedsmap }()
+	ctx.smap = &Smap{Smap: map[string]*ServerInfo{
+		"t1":  {DaemonID: "t1", DirectURL: good.URL},
+		"t2":  {DaemonID: "t2", DirectURL: good.URL},
+		"bad": {DaemonID: "bad", DirectURL: bad.URL},
+	}}
+	p := &proxyrunner{}
+	p.httpclient = &http.Client{}
+	c := p.getcapacity(&GetMsg{What: GetCapacity})
+	if len(c.Targets) != 2 || *c.Buckets["bucket"] != (Cachecapacity{Objects: 2, Bytes: 20}) {
+		t.Errorf("Unexpected cluster capacity %+v", c.Buckets["bucket"])
+	}
+	if len(c.Errors) != 1 || c.Errors["bad"] == "" {
+		t.Errorf("Unexpected per-target errors %+v", c.Errors)
+	}
+}
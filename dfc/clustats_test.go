@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Cluster stats: parallel queries, a slow and a failed target, the totals.
+//
+// Example run:
+// 	go test -v -run=clugetstats
+//
+package dfc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_clugetstats(t *testing.T) {
+	stats := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"numget": 2, "hitratio": 50, "aws": {"numcoldget": 1},
+			"buckets": {"b1": {"numget": 2}}}`))
+	}
+	good := httptest.NewServer(http.HandlerFunc(stats))
+	defer good.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		stats(w, r)
+	}))
+	defer slow.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", http.StatusBadRequest)
+	}))
+	defer bad.Close()
+
+	savedsmap, savedrg, savedtimeout := ctx.smap, ctx.rg, ctx.config.Proxy.StatsTimeout
+	defer func() {
+		ctx.smap, ctx.rg, ctx.config.Proxy.StatsTimeout = savedsmap, savedrg, savedtimeout
+	}()
+	ctx.smap = &Smap{Smap: map[string]*ServerInfo{
+		"good1": {DaemonID: "good1", DirectURL: good.URL},
+		"good2": {DaemonID: "good2", DirectURL: good.URL},
+		"slow":  {DaemonID: "slow", DirectURL: slow.URL},
+		"bad":   {DaemonID: "bad", DirectURL: bad.URL},
+	}}
+	ctx.rg = &rungroup{runmap: map[string]runner{
+		xproxystats: &proxystatsrunner{statsrunner: statsrunner{reg: proxyreg}}}}
+	ctx.config.Proxy.StatsTimeout = 100 * time.Millisecond
+
+	p := &proxyrunner{}
+	p.httpclient = &http.Client{}
+	r := httptest.NewRequest(http.MethodGet, "/"+Rversion+"/"+Rcluster, nil)
+	w := httptest.NewRecorder()
+	started := time.Now()
+	p.httpclugetstats(w, r, []byte(`{"what": "stats"}`))
+	if elapsed := time.Since(started); elapsed > 400*time.Millisecond {
+		t.Errorf("Expected the slow target to time out, took %v", elapsed)
+	}
+	var out Allstats
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Storstats) != 2 || out.Storstats["good1"] == nil || out.Storstats["good2"] == nil {
+		t.Errorf("Unexpected target stats %+v", out.Storstats)
+	}
+	if len(out.Errors) != 2 || out.Errors["slow"] == "" || out.Errors["bad"] == "" {
+		t.Errorf("Unexpected errors %+v", out.Errors)
+	}
+	if out.Totals["numget"] != 4 || out.Totals["aws.numcoldget"] != 2 {
+		t.Errorf("Unexpected totals %+v", out.Totals)
+	}
+	if _, ok := out.Totals["hitratio"]; ok {
+		t.Errorf("Derived stats are not expected in the totals %+v", out.Totals)
+	}
+	if out.Buckets["b1"]["numget"] != 4 {
+		t.Errorf("Unexpected bucket stats %+v", out.Buckets)
+	}
+}
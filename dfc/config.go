@@ -7,9 +7,11 @@ package dfc
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -17,16 +19,26 @@ import (
 
 // dfconfig specifies common daemon's configuration structure in JSON format.
 type dfconfig struct {
-	ID            string        `json:"id"`
-	Logdir        string        `json:"logdir"`
-	Loglevel      string        `json:"loglevel"`
-	CloudProvider string        `json:"cloudprovider"`
-	StatsTime     time.Duration `json:"stats_time"`
-	HttpTimeout   time.Duration `json:"http_timeout"`
-	Listen        listenconfig  `json:"listen"`
-	Proxy         proxyconfig   `json:"proxy"`
-	S3            s3config      `json:"s3"`
-	Cache         cacheconfig   `json:"cache"`
+	ID             string         `json:"id"`
+	Logdir         string         `json:"logdir"`
+	Confdir        string         `json:"confdir"`
+	Loglevel       string         `json:"loglevel"`
+	CloudProvider  string         `json:"cloudprovider"`  // default provider
+	CloudProviders []string       `json:"cloudproviders"` // all providers that targets instantiate (default: cloudprovider)
+	StatsTime      time.Duration  `json:"stats_time"`
+	ClientStats    bool           `json:"client_stats"` // per-client breakdown, see bucketstats.go
+	HttpTimeout    time.Duration  `json:"http_timeout"`
+	Listen         listenconfig   `json:"listen"`
+	TLS            tlsconfig      `json:"tls"`
+	Auth           authconfig     `json:"auth"`
+	Proxy          proxyconfig    `json:"proxy"`
+	S3             s3config       `json:"s3"`
+	Cache          cacheconfig    `json:"cache"`
+	Mirror         mirrorconfig   `json:"mirror"`
+	EC             ecconfig       `json:"ec"`
+	Throttle       throttleconfig `json:"throttle"`
+	History        historyconfig  `json:"stats_history"`
+	StatsD         statsdconfig   `json:"statsd"`
 }
 
 const (
@@ -34,6 +46,56 @@ const (
 	googlecloud = "gcp"
 )
 
+// optional provider namespace in the bucket name, e.g. /v1/files/gs:mybucket/myobject
+const (
+	nsamazon = "s3:"
+	nsgoogle = "gs:"
+)
+
+func parsebucket(s string) (provider, bucket string) {
+	switch {
+	case strings.HasPrefix(s, nsamazon):
+		return amazoncloud, s[len(nsamazon):]
+	case strings.HasPrefix(s, nsgoogle):
+		return googlecloud, s[len(nsgoogle):]
+	}
+	return "", s
+}
+
+// the name under which a bucket's objects are placed (HRW) and cached: cloud buckets of the providers
+// other than the default one are qualified with the provider namespace, e.g. gs:mybucket, so that
+// the same-named buckets of different providers do not collide; see parsebucket for the reverse
+func cachebucket(nsprovider, bucket string) string {
+	provider := nsprovider
+	if ctx.bmd != nil {
+		if ctx.bmd.islocal(bucket) {
+			return bucket
+		}
+		if provider == "" {
+			provider = ctx.bmd.getprops(bucket).CloudProvider
+		}
+	}
+	if provider == "" || provider == ctx.config.CloudProvider {
+		return bucket
+	}
+	switch provider {
+	case amazoncloud:
+		return nsamazon + bucket
+	case googlecloud:
+		return nsgoogle + bucket
+	}
+	return bucket
+}
+
+func isprovider(provider string) bool {
+	for _, p := range ctx.config.CloudProviders {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
+
 // s3config specifies  Amazon S3 specific configuration parameters
 type s3config struct {
 	Maxconcurrdownld uint32 `json:"maxconcurrdownld"` // Concurent Download for a session.
@@ -43,12 +105,52 @@ type s3config struct {
 
 // caching configuration
 type cacheconfig struct {
-	CachePath       string        `json:"cachepath"`       // caching path
-	CachePathCount  int           `json:"cachepathcount"`  // num cache paths
-	ErrorThreshold  int           `json:"errorthreshold"`  // error threshold for the specific cache path to become unusable
-	FSLowWaterMark  uint32        `json:"fslowwatermark"`  // capacity usage low watermark
-	FSHighWaterMark uint32        `json:"fshighwatermark"` // capacity usage high watermark
-	DontEvictTime   time.Duration `json:"dont_evict_time"` // eviction is not permitted during [atime, atime + dont]
+	CachePath       string        `json:"cachepath"`        // caching path
+	CachePathCount  int           `json:"cachepathcount"`   // num cache paths
+	ErrorThreshold  int           `json:"errorthreshold"`   // error threshold for the specific cache path to become unusable
+	FSLowWaterMark  uint32        `json:"fslowwatermark"`   // capacity usage low watermark
+	FSHighWaterMark uint32        `json:"fshighwatermark"`  // capacity usage high watermark
+	DontEvictTime   time.Duration `json:"dont_evict_time"`  // eviction is not permitted during [atime, atime + dont]
+	EvictPolicy     string        `json:"evict_policy"`     // one of: lru (default), lfu, gdsf, arc - see eviction.go
+	AtimeFlushTime  time.Duration `json:"atime_flush_time"` // access index flush interval, see atime.go
+	ObjectTTL       time.Duration `json:"object_ttl"`       // default TTL of the cached objects (0: never expire), see expiry.go
+	ExpiryTime      time.Duration `json:"expiry_time"`      // expiry job interval (0: expired objects are removed on access only)
+	PrefetchWorkers int           `json:"prefetch_workers"` // concurrent downloads per target (0: default), see prefetch.go
+}
+
+// local mirroring: additional copies of an object on the next-best (HRW) mountpaths
+// NOTE: BucketProps.Mirror overrides the default
+type mirrorconfig struct {
+	Copies int `json:"copies"` // default number of local copies, including the object itself (0 or 1: no mirroring)
+}
+
+// background jobs vs. foreground traffic, see throttle.go (zero values: defaults)
+type throttleconfig struct {
+	DiskUtilLow  int           `json:"disk_util_low"`   // no throttling below this disk utilization, percent
+	DiskUtilHigh int           `json:"disk_util_high"`  // max throttling at and above
+	LatencyHigh  time.Duration `json:"latency_high"`    // max throttling at and above this GET latency (0: latency is ignored)
+	SleepMax     time.Duration `json:"sleep_max"`       // max delay per file-level operation
+	MaxOpsPerSec int           `json:"max_ops_per_sec"` // max file-level operations per second, all jobs combined (0: unlimited)
+}
+
+// in-memory stats history, see history.go (zero values: defaults)
+type historyconfig struct {
+	Resolution time.Duration `json:"resolution"` // interval between snapshots (0: stats_time)
+	Retention  time.Duration `json:"retention"`  // how far back to keep them (0: 1 hour)
+}
+
+// StatsD exporter, see statsd.go
+type statsdconfig struct {
+	Address string `json:"address"` // UDP host:port (empty: disabled)
+	Prefix  string `json:"prefix"`  // metric name prefix, followed by the daemon ID (empty: "dfc")
+}
+
+// erasure coding of locally written objects
+type ecconfig struct {
+	Enabled      bool  `json:"enabled"`
+	DataSlices   int   `json:"data_slices"`   // number of data slices
+	ParitySlices int   `json:"parity_slices"` // number of parity slices (and replicas of small objects)
+	ObjSizeLimit int64 `json:"objsize_limit"` // objects smaller than that are replicated rather than encoded
 }
 
 // daemon listenig params
@@ -57,10 +159,28 @@ type listenconfig struct {
 	Port  string `json:"port"`  // Listening port.
 }
 
+// HTTPS, see tls.go
+type tlsconfig struct {
+	Enabled     bool   `json:"enabled"`
+	Certificate string `json:"certificate"` // PEM file: this daemon's certificate
+	Key         string `json:"key"`         // PEM file: its private key
+	CA          string `json:"ca"`          // PEM file: CA certificates to verify the peers (empty: system roots)
+	Mutual      bool   `json:"mutual"`      // verify client certificates; required for the intra-cluster routes, see tls.go
+	SkipVerify  bool   `json:"skip_verify"` // do not verify the peers' certificates (testing only)
+}
+
+// authentication, see auth.go
+type authconfig struct {
+	Enabled bool   `json:"enabled"`
+	Secret  string `json:"secret"` // HMAC key to sign and verify the tokens, the same for all daemons
+}
+
 // proxyconfig specifies proxy's well-known address as http://<ipaddress>:<portnumber>
 type proxyconfig struct {
 	URL      string `json:"url"`      // used to register caching servers
 	Passthru bool   `json:"passthru"` // false: get then redirect, true (default): redirect right away
+	// max time to wait for a target's stats (0: default), see httpclugetstats
+	StatsTimeout time.Duration `json:"stats_timeout"`
 }
 
 // Load and validate daemon's config
@@ -95,6 +215,51 @@ func initconfigparam(configfile, loglevel, role string, statstime time.Duration)
 		}
 
 	}
+	if err = validateproviders(); err != nil {
+		glog.Errorln(err)
+		return err
+	}
+	if _, err = newevictpolicy(ctx.config.Cache.EvictPolicy); err != nil {
+		glog.Errorln(err)
+		return err
+	}
+	if c := &ctx.config.Cache; c.ObjectTTL < 0 || c.ExpiryTime < 0 {
+		err = fmt.Errorf("Invalid object TTL %v or expiry time %v", c.ObjectTTL, c.ExpiryTime)
+		glog.Errorln(err)
+		return err
+	}
+	if h := &ctx.config.History; h.Resolution < 0 || h.Retention < 0 ||
+		(h.Resolution > 0 && h.Retention > 0 && h.Retention < h.Resolution) {
+		err = fmt.Errorf("Invalid stats history configuration %+v", *h)
+		glog.Errorln(err)
+		return err
+	}
+	if t := &ctx.config.TLS; t.Enabled && (t.Certificate == "" || t.Key == "" || (t.Mutual && t.CA == "")) {
+		err = fmt.Errorf("Invalid TLS configuration %+v: certificate and key (and CA, if mutual) are required", *t)
+		glog.Errorln(err)
+		return err
+	}
+	if ctx.config.Auth.Enabled && ctx.config.Auth.Secret == "" {
+		err = fmt.Errorf("Invalid auth configuration: missing secret")
+		glog.Errorln(err)
+		return err
+	}
+	if ctx.config.Proxy.StatsTimeout < 0 {
+		err = fmt.Errorf("Invalid stats timeout %v", ctx.config.Proxy.StatsTimeout)
+		glog.Errorln(err)
+		return err
+	}
+	if t := &ctx.config.Throttle; t.DiskUtilLow < 0 || t.DiskUtilLow > t.DiskUtilHigh || t.DiskUtilHigh > 100 || t.MaxOpsPerSec < 0 {
+		err = fmt.Errorf("Invalid throttle configuration %+v", *t)
+		glog.Errorln(err)
+		return err
+	}
+	if ctx.config.EC.Enabled {
+		if _, err = newRScodec(ctx.config.EC.DataSlices, ctx.config.EC.ParitySlices); err != nil {
+			glog.Errorln(err)
+			return err
+		}
+	}
 	if err = CreateDir(ctx.config.Logdir); err != nil {
 		glog.Errorf("Failed to create log dir %q, err: %v", ctx.config.Logdir, err)
 		return err
@@ -123,16 +288,39 @@ func initconfigparam(configfile, loglevel, role string, statstime time.Duration)
 	return err
 }
 
+func validateproviders() error {
+	if len(ctx.config.CloudProviders) == 0 {
+		ctx.config.CloudProviders = []string{ctx.config.CloudProvider}
+	}
+	for _, provider := range ctx.config.CloudProviders {
+		if provider != amazoncloud && provider != googlecloud {
+			return fmt.Errorf("Invalid cloud provider %q (expecting %q or %q)", provider, amazoncloud, googlecloud)
+		}
+	}
+	if ctx.config.CloudProvider == "" {
+		ctx.config.CloudProvider = ctx.config.CloudProviders[0]
+	}
+	if !isprovider(ctx.config.CloudProvider) {
+		return fmt.Errorf("Default cloud provider %q is not one of the %v", ctx.config.CloudProvider, ctx.config.CloudProviders)
+	}
+	return nil
+}
+
 // Read JSON config file and unmarshal json content into config struct.
 func getConfig(fpath string) {
-	raw, err := ioutil.ReadFile(fpath)
-	if err != nil {
-		glog.Errorf("Failed to read config %q, err: %v", fpath, err)
+	if err := readconfig(fpath, &ctx.config); err != nil {
+		glog.Errorln(err)
 		os.Exit(1)
 	}
-	err = json.Unmarshal(raw, &ctx.config)
+}
+
+func readconfig(fpath string, config *dfconfig) error {
+	raw, err := ioutil.ReadFile(fpath)
 	if err != nil {
-		glog.Errorf("Failed to json-unmarshal config %q, err: %v", fpath, err)
-		os.Exit(1)
+		return fmt.Errorf("Failed to read config %q, err: %v", fpath, err)
+	}
+	if err = json.Unmarshal(raw, config); err != nil {
+		return fmt.Errorf("Failed to json-unmarshal config %q, err: %v", fpath, err)
 	}
+	return nil
 }
@@ -5,6 +5,7 @@
 package dfc
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -22,6 +23,7 @@ const (
 	xsignal     = "signal"
 	xproxystats = "proxystats"
 	xstorstats  = "storstats"
+	xatime      = "atime"
 )
 
 //====================
@@ -37,17 +39,33 @@ var ctx = &daemon{}
 //
 //=========================================
 type ActionMsg struct {
-	Action string `json:"action"` // shutdown, restart - see the const below
-	Param1 string `json:"param1"` // action-specific params
-	Param2 string `json:"param2"`
+	Action string          `json:"action"` // shutdown, restart - see the const below
+	Param1 string          `json:"param1"` // action-specific params
+	Param2 string          `json:"param2"`
+	Value  json.RawMessage `json:"value,omitempty"` // action-specific JSON, e.g. PinMsg
 }
 
 // ActionMsg.Action enum
 const (
-	ActionShutdown = "shutdown"
-	ActionSyncSmap = "syncsmap" // synchronize cluster map aka Smap across all targets
+	ActionShutdown  = "shutdown"
+	ActionSyncSmap  = "syncsmap"  // synchronize cluster map aka Smap across all targets
+	ActionCreateLB  = "createlb"  // create local bucket named Param1
+	ActionDestroyLB = "destroylb" // destroy local bucket named Param1 along with all its objects
+	ActionPin       = "pin"       // pin bucket Param1 or its objects as per the PinMsg value, see pin.go
+	ActionUnpin     = "unpin"     // remove the pin that was created with the same bucket and PinMsg
+	ActionLRU       = "lru"       // start (with the LRUMsg value), pause, resume, or abort eviction - see lrujob.go
+	ActionAbortXact = "abortxact" // abort the running xaction given its ID or kind (Param1) - see xaction.go
+	ActionEvict     = "evict"     // evict cached objects of bucket Param1 as per the EvictMsg value, see evict.go
+	ActionPrefetch  = "prefetch"  // prefetch objects of bucket Param1 as per the PrefetchMsg value, see prefetch.go
 )
 
+// ActionPin and ActionUnpin value; neither objname nor prefix: the entire bucket
+type PinMsg struct {
+	Objname string        `json:"objname,omitempty"`
+	Prefix  string        `json:"prefix,omitempty"`
+	TTL     time.Duration `json:"ttl,omitempty"` // unpin automatically after TTL (ActionPin only); 0: never
+}
+
 type GetMsg struct {
 	What   string `json:"what"` // specifies what exactly are we getting
 	Param1 string `json:"param1"`
@@ -56,8 +74,34 @@ type GetMsg struct {
 
 // GetMsg.What enum
 const (
-	GetConfig = "config"
-	GetStats  = "stats"
+	GetConfig   = "config"
+	GetStats    = "stats"
+	GetBucketMD = "bucketmd" // local buckets and bucket properties
+	GetPins     = "pins"     // pinned buckets, prefixes and objects (target only)
+	GetLRU      = "lru"      // LRU job status
+	GetXactions = "xactions" // running and recently finished xactions
+	GetHistory  = "history"  // stats time series, see history.go
+	GetCapacity = "capacity" // capacity and cache content, see capacity.go
+)
+
+// GET, PUT '{BucketProps}' /v1/buckets/bucket-name
+// NOTE: zero values stand for the daemon-wide defaults
+type BucketProps struct {
+	CloudProvider string        `json:"cloud_provider,omitempty"` // "aws" or "gcp" (cloud buckets only)
+	NoEviction    bool          `json:"no_eviction,omitempty"`    // never evict cached objects of this bucket
+	TTL           time.Duration `json:"ttl,omitempty"`            // cached objects older than that are stale (0: Cache.ObjectTTL)
+	Checksum      string        `json:"checksum,omitempty"`       // ChecksumNone or ChecksumXXHash
+	Mirror        int           `json:"mirror,omitempty"`         // number of local copies, see mirror.go
+	ReadOnly      bool          `json:"read_only,omitempty"`      // PUT and DELETE are not permitted
+	Quota         int64         `json:"quota,omitempty"`          // max bytes cached per target (cloud buckets only), see quota.go
+	Readers       []string      `json:"readers,omitempty"`        // users permitted to read (none: all), see auth.go
+	Writers       []string      `json:"writers,omitempty"`        // ditto, to write
+}
+
+// BucketProps.Checksum enum
+const (
+	ChecksumNone   = "none"
+	ChecksumXXHash = "xxhash"
 )
 
 type CopyMsg struct {
@@ -77,11 +121,22 @@ type Smap struct {
 	lock    *sync.Mutex
 }
 
+// bucket metadata: local (DFC-only) buckets and per-bucket properties,
+// versioned by the proxy and distributed to targets along with the Smap
+type bucketmd struct {
+	LBmap   map[string]bool         `json:"l_bmap"`
+	BProps  map[string]*BucketProps `json:"b_props"`
+	Pins    []*pin                  `json:"pins,omitempty"` // see pin.go
+	Version int64                   `json:"version"`
+	lock    *sync.Mutex
+}
+
 // daemon instance: proxy or storage target
 type daemon struct {
 	smap       *Smap
+	bmd        *bucketmd
 	config     dfconfig
-	mountpaths map[string]mountPath
+	mountpaths map[string]*mountPath
 	rg         *rungroup
 }
 
@@ -152,6 +207,114 @@ func (m *Smap) version() int64 {
 	return atomic.LoadInt64(&m.Version)
 }
 
+//====================
+//
+// bucket metadata
+//
+//====================
+func newbucketmd() *bucketmd {
+	return &bucketmd{
+		LBmap:  make(map[string]bool, 4),
+		BProps: make(map[string]*BucketProps, 4),
+		lock:   &sync.Mutex{},
+	}
+}
+
+func (m *bucketmd) add(bucket string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.LBmap[bucket] {
+		return false
+	}
+	m.LBmap[bucket] = true
+	m.Version++
+	return true
+}
+
+func (m *bucketmd) del(bucket string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.LBmap[bucket] {
+		return false
+	}
+	delete(m.LBmap, bucket)
+	delete(m.BProps, bucket)
+	m.Version++
+	return true
+}
+
+func (m *bucketmd) islocal(bucket string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.LBmap[bucket]
+}
+
+// returns a copy; zero props if not set
+func (m *bucketmd) getprops(bucket string) (props BucketProps) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if p, ok := m.BProps[bucket]; ok {
+		props = *p
+	}
+	return
+}
+
+// nil props: revert to defaults
+func (m *bucketmd) setprops(bucket string, props *BucketProps) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if props == nil {
+		delete(m.BProps, bucket)
+	} else {
+		p := *props
+		m.BProps[bucket] = &p
+	}
+	m.Version++
+}
+
+// replaces the content with a newer version; returns the local buckets that are gone
+func (m *bucketmd) update(newm *bucketmd) (removed []string, ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if newm.Version <= m.Version {
+		return nil, false
+	}
+	for bucket := range m.LBmap {
+		if !newm.LBmap[bucket] {
+			removed = append(removed, bucket)
+		}
+	}
+	m.LBmap, m.BProps, m.Pins, m.Version = newm.LBmap, newm.BProps, newm.Pins, newm.Version
+	if m.LBmap == nil {
+		m.LBmap = make(map[string]bool, 4)
+	}
+	if m.BProps == nil {
+		m.BProps = make(map[string]*BucketProps, 4)
+	}
+	return removed, true
+}
+
+// buckets that have quotas
+func (m *bucketmd) quotas() map[string]int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	quotas := make(map[string]int64)
+	for bucket, props := range m.BProps {
+		if props.Quota > 0 {
+			quotas[bucket] = props.Quota
+		}
+	}
+	return quotas
+}
+
+func (m *bucketmd) marshal() []byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	jsbytes, err := json.Marshal(m)
+	assert(err == nil, err)
+	return jsbytes
+}
+
 //====================
 //
 // rungroup
@@ -222,12 +385,29 @@ func dfcinit() {
 	flag.StringVar(&conffile, "config", "", "config filename")
 	flag.StringVar(&loglevel, "loglevel", "", "glog loglevel")
 	flag.DurationVar(&statstime, "statstime", 0, "http and capacity utilization statistics log interval")
+	// print a token signed with the configured auth secret and exit, see auth.go
+	var (
+		tokensubject, tokenrole string
+		tokenttl                time.Duration
+	)
+	flag.StringVar(&tokensubject, "token", "", "print a token for the given user and exit")
+	flag.StringVar(&tokenrole, "tokenrole", RoleUser, "token role: admin OR user")
+	flag.DurationVar(&tokenttl, "tokenttl", 24*time.Hour, "token lifetime (0: never expires)")
 
 	flag.Parse()
 	if conffile == "" {
 		fmt.Fprintf(os.Stderr, "Usage: go run dfc.go -role=<proxy|target> -config=<json> [...]\n")
 		os.Exit(2)
 	}
+	if tokensubject != "" {
+		token, err := configtoken(conffile, tokensubject, tokenrole, tokenttl)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(token)
+		os.Exit(0)
+	}
 	assert(role == xproxy || role == xtarget, "Invalid flag: role="+role)
 	err := initconfigparam(conffile, loglevel, role, statstime)
 	if err != nil {
@@ -240,13 +420,15 @@ func dfcinit() {
 		runarr: make([]runner, 0, 4),
 		runmap: make(map[string]runner),
 	}
+	ctx.bmd = newbucketmd()
 	if role == xproxy {
 		ctx.smap = &Smap{Smap: make(map[string]*ServerInfo, 8), lock: &sync.Mutex{}}
 		ctx.rg.add(&proxyrunner{}, xproxy)
-		ctx.rg.add(&proxystatsrunner{}, xproxystats)
+		ctx.rg.add(&proxystatsrunner{statsrunner: statsrunner{reg: proxyreg}}, xproxystats)
 	} else {
 		ctx.rg.add(&targetrunner{}, xtarget)
-		ctx.rg.add(&storstatsrunner{}, xstorstats)
+		ctx.rg.add(&storstatsrunner{statsrunner: statsrunner{reg: storreg}}, xstorstats)
+		ctx.rg.add(&atimerunner{}, xatime)
 	}
 	ctx.rg.add(&sigrunner{}, xsignal)
 }
@@ -283,9 +465,8 @@ func getproxystatsrunner() *proxystatsrunner {
 	return rr
 }
 
-func getproxystats() *Proxystats {
-	rr := getproxystatsrunner()
-	return &rr.stats
+func getproxystats() *statsregistry {
+	return proxyreg
 }
 
 func getproxy() *proxyrunner {
@@ -302,9 +483,17 @@ func getstorstatsrunner() *storstatsrunner {
 	return rr
 }
 
-func getstorstats() *Storstats {
-	rr := getstorstatsrunner()
-	return &rr.stats
+func getstorstats() *statsregistry {
+	return storreg
+}
+
+// NOTE: returns nil if there's no such runner (proxy, *_test)
+func getatimerunner() *atimerunner {
+	if ctx.rg == nil {
+		return nil
+	}
+	rr, _ := ctx.rg.runmap[xatime].(*atimerunner)
+	return rr
 }
 
 func initusedstats() {
@@ -323,10 +512,3 @@ func getusedstats() usedstats {
 	assert(ok)
 	return rr.used
 }
-
-func getcloudif() cinterface {
-	r := ctx.rg.runmap[xtarget]
-	rr, ok := r.(*targetrunner)
-	assert(ok)
-	return rr.cloudif
-}
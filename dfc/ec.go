@@ -0,0 +1,629 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// Erasure coding of locally written objects: the object itself stays on its HRW target,
+// while its data and parity slices go to the next-best targets in the HRW order.
+// Objects smaller than ObjSizeLimit are replicated instead (ParitySlices copies).
+// A target that does not have the object (e.g., after the Smap change) restores it
+// from the slices upon the first GET.
+
+const (
+	ecSliceDir     = "/.ec/slice"     // received slices (and replicas)
+	ecSliceMetaDir = "/.ec/slicemeta" // their metadata
+	ecMetaDir      = "/.ec/meta"      // metadata of the locally stored encoded objects
+	ecChunkSize    = 1024 * 1024      // encoding/decoding is done in chunks of up to 1MB per slice
+	HeaderECMeta   = "X-DFC-EC-Meta"  // JSON-encoded ecmeta that accompanies each slice
+)
+
+type ecmeta struct {
+	Size    int64    `json:"size"`              // object size
+	Data    int      `json:"data"`              // number of data slices (0: replicated)
+	Parity  int      `json:"parity"`            // number of parity slices or replicas
+	SliceID int      `json:"slice_id"`          // 1 through data+parity for slices, 0 for a full replica
+	Targets []string `json:"targets,omitempty"` // the object itself: targets that store its slices
+}
+
+func ecslicesize(size int64, data int) int64 {
+	return (size + int64(data) - 1) / int64(data)
+}
+
+// local path of a slice or metadata file
+func ecfqn(dir, bucket, objname string) string {
+	mpath := hrwMpath(bucket + "/" + objname)
+	assert(len(mpath) > 0)
+	return mpath + dir + "/" + bucket + "/" + objname
+}
+
+func writeecmeta(fqn string, meta *ecmeta) error {
+	jsbytes, err := json.Marshal(meta)
+	assert(err == nil, err)
+	if err = CreateDir(filepath.Dir(fqn)); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fqn, jsbytes, 0644)
+}
+
+func readecmeta(fqn string) (*ecmeta, error) {
+	jsbytes, err := ioutil.ReadFile(fqn)
+	if err != nil {
+		return nil, err
+	}
+	meta := &ecmeta{}
+	if err = json.Unmarshal(jsbytes, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// up to n targets, other than self, to store the object's slices
+func (t *targetrunner) ectargets(bucket, objname string, n int) []*ServerInfo {
+	sids := hrwTargets(bucket+"/"+objname, t.smap, t.smap.count())
+	out := make([]*ServerInfo, 0, n)
+	for _, sid := range sids {
+		if sid == t.si.DaemonID {
+			continue
+		}
+		if len(out) == n {
+			break
+		}
+		out = append(out, t.smap.get(sid))
+	}
+	return out
+}
+
+//===========================
+//
+// encoding
+//
+//===========================
+
+// encodes (or replicates) a locally stored object and sends the result to other targets
+func (t *targetrunner) ecencode(bucket, objname, fqn string) error {
+	cfg := &ctx.config.EC
+	file, err := os.Open(fqn)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	finfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := finfo.Size()
+	meta := &ecmeta{Size: size, Data: cfg.DataSlices, Parity: cfg.ParitySlices}
+	if size < cfg.ObjSizeLimit {
+		meta.Data = 0
+	}
+	n := meta.Data + meta.Parity
+	targets := t.ectargets(bucket, objname, n)
+	if len(targets) < n {
+		return fmt.Errorf("Cannot erasure-code %s/%s: not enough targets (%d, expecting %d)",
+			bucket, objname, len(targets), n)
+	}
+	if meta.Data == 0 {
+		for _, si := range targets {
+			slicemeta := *meta
+			if err = t.ecsend(si, bucket, objname, &slicemeta, io.NewSectionReader(file, 0, size)); err != nil {
+				return err
+			}
+			meta.Targets = append(meta.Targets, si.DaemonID)
+		}
+		return writeecmeta(ecfqn(ecMetaDir, bucket, objname), meta)
+	}
+	parityfqns, err := ecparity(file, meta, fqn)
+	defer func() {
+		for _, pfqn := range parityfqns {
+			os.Remove(pfqn)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	slicesize := ecslicesize(size, meta.Data)
+	for i, si := range targets {
+		var body io.Reader
+		slicemeta := *meta
+		slicemeta.SliceID = i + 1
+		if i < meta.Data {
+			off := int64(i) * slicesize
+			sz := slicesize
+			if off+sz > size {
+				sz = size - off
+			}
+			if sz < 0 {
+				sz = 0
+			}
+			body = io.NewSectionReader(file, off, sz)
+		} else {
+			pfile, err := os.Open(parityfqns[i-meta.Data])
+			if err != nil {
+				return err
+			}
+			body = pfile
+			defer pfile.Close()
+		}
+		if err = t.ecsend(si, bucket, objname, &slicemeta, body); err != nil {
+			return err
+		}
+		meta.Targets = append(meta.Targets, si.DaemonID)
+	}
+	if glog.V(3) {
+		glog.Infof("Erasure-coded %s/%s: %d data, %d parity slices, targets %v",
+			bucket, objname, meta.Data, meta.Parity, meta.Targets)
+	}
+	return writeecmeta(ecfqn(ecMetaDir, bucket, objname), meta)
+}
+
+// computes parity slices into temporary local files
+func ecparity(file *os.File, meta *ecmeta, fqn string) (parityfqns []string, err error) {
+	codec, err := newRScodec(meta.Data, meta.Parity)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		slicesize = ecslicesize(meta.Size, meta.Data)
+		slices    = make([][]byte, meta.Data+meta.Parity)
+		pfiles    = make([]*os.File, meta.Parity)
+	)
+	defer func() {
+		for _, pfile := range pfiles {
+			if pfile != nil {
+				pfile.Close()
+			}
+		}
+	}()
+	for i := 0; i < meta.Parity; i++ {
+		pfqn := filepath.Dir(fqn) + "/." + filepath.Base(fqn) + ".parity" + strconv.Itoa(i)
+		parityfqns = append(parityfqns, pfqn)
+		if pfiles[i], err = os.Create(pfqn); err != nil {
+			return
+		}
+	}
+	for off := int64(0); off < slicesize; off += ecChunkSize {
+		chunk := int64(ecChunkSize)
+		if off+chunk > slicesize {
+			chunk = slicesize - off
+		}
+		for i := range slices {
+			slices[i] = make([]byte, chunk)
+		}
+		for i := 0; i < meta.Data; i++ {
+			if _, err = file.ReadAt(slices[i], int64(i)*slicesize+off); err != nil && err != io.EOF {
+				return
+			}
+		}
+		codec.encode(slices)
+		for i := 0; i < meta.Parity; i++ {
+			if _, err = pfiles[i].Write(slices[meta.Data+i]); err != nil {
+				return
+			}
+		}
+	}
+	return parityfqns, nil
+}
+
+func (t *targetrunner) ecsend(si *ServerInfo, bucket, objname string, meta *ecmeta, body io.Reader) error {
+	jsbytes, err := json.Marshal(meta)
+	assert(err == nil, err)
+	url := si.DirectURL + "/" + Rversion + "/" + Rslices + "/" + bucket + "/" + objname
+	request, err := http.NewRequest(http.MethodPut, url, body)
+	assert(err == nil, err)
+	request.Header.Set(HeaderECMeta, string(jsbytes))
+	response, err := t.dataclient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Failed to send slice %d of %s/%s to %s, err: %v", meta.SliceID, bucket, objname, si.DaemonID, err)
+	}
+	ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("Failed to send slice %d of %s/%s to %s, status %d",
+			meta.SliceID, bucket, objname, si.DaemonID, response.StatusCode)
+	}
+	return nil
+}
+
+//===========================
+//
+// restoring
+//
+//===========================
+type ecslice struct {
+	meta *ecmeta
+	fqn  string
+	sid  string
+}
+
+// orders the slices as ecencode does, so that ecrebuildone can compare the targets:
+// by slice ID and, for the replicas, by the HRW order of the targets
+func ecsortslices(slices []*ecslice, name string, smap *Smap) {
+	rank := make(map[string]int, len(smap.Smap))
+	for i, sid := range hrwTargets(name, smap, len(smap.Smap)) {
+		rank[sid] = i
+	}
+	sort.Slice(slices, func(i, j int) bool {
+		if slices[i].meta.SliceID != slices[j].meta.SliceID {
+			return slices[i].meta.SliceID < slices[j].meta.SliceID
+		}
+		return rank[slices[i].sid] < rank[slices[j].sid]
+	})
+}
+
+// the slice (or replica) of the object stored on this target, if any; looks in all
+// mountpaths, as the object's HRW mountpath may have changed since the slice was received
+func eclocalslice(bucket, objname string) (fqn string, meta *ecmeta) {
+	for mpath := range ctx.mountpaths {
+		m, err := readecmeta(mpath + ecSliceMetaDir + "/" + bucket + "/" + objname)
+		if err != nil {
+			continue
+		}
+		f := mpath + ecSliceDir + "/" + bucket + "/" + objname
+		if _, err = os.Stat(f); err == nil {
+			return f, m
+		}
+	}
+	return "", nil
+}
+
+// restores the object from its slices (or a replica) found locally and on other targets
+func (t *targetrunner) ecrestore(bucket, objname, fqn string) error {
+	var (
+		slices = make([]*ecslice, 0, t.smap.count())
+		lock   = &sync.Mutex{}
+		wg     = &sync.WaitGroup{}
+		idx    int
+	)
+	// the new owner is often one of the slice holders
+	if slicefqn, meta := eclocalslice(bucket, objname); meta != nil {
+		tmpfqn := filepath.Dir(fqn) + "/." + filepath.Base(fqn) + ".slice0"
+		if err := copyfile(slicefqn, tmpfqn); err != nil {
+			glog.Errorf("Failed to read the local slice %q, err: %v", slicefqn, err)
+		} else {
+			slices = append(slices, &ecslice{meta: meta, fqn: tmpfqn, sid: t.si.DaemonID})
+		}
+	}
+	for sid, si := range t.smap.Smap {
+		if sid == t.si.DaemonID {
+			continue
+		}
+		wg.Add(1)
+		idx++
+		go func(si *ServerInfo, idx int) {
+			defer wg.Done()
+			tmpfqn := filepath.Dir(fqn) + "/." + filepath.Base(fqn) + ".slice" + strconv.Itoa(idx)
+			meta, err := t.ecreceive(si, bucket, objname, tmpfqn)
+			if err != nil || meta == nil {
+				return
+			}
+			lock.Lock()
+			slices = append(slices, &ecslice{meta: meta, fqn: tmpfqn, sid: si.DaemonID})
+			lock.Unlock()
+		}(si, idx)
+	}
+	wg.Wait()
+	defer func() {
+		for _, slice := range slices {
+			os.Remove(slice.fqn)
+		}
+	}()
+	if len(slices) == 0 {
+		return fmt.Errorf("No slices of %s/%s found", bucket, objname)
+	}
+	ecsortslices(slices, bucket+"/"+objname, t.smap)
+	meta := &ecmeta{Size: slices[0].meta.Size, Data: slices[0].meta.Data, Parity: slices[0].meta.Parity}
+	for _, slice := range slices {
+		meta.Targets = append(meta.Targets, slice.sid)
+	}
+	for _, slice := range slices {
+		if slice.meta.SliceID == 0 {
+			if err := os.Rename(slice.fqn, fqn); err != nil {
+				return err
+			}
+			glog.Infof("Restored %s/%s from the replica at %s", bucket, objname, slice.sid)
+			return writeecmeta(ecfqn(ecMetaDir, bucket, objname), meta)
+		}
+	}
+	if err := ecdecode(slices, meta, fqn); err != nil {
+		os.Remove(fqn)
+		return err
+	}
+	glog.Infof("Restored %s/%s from %d slices", bucket, objname, len(slices))
+	return writeecmeta(ecfqn(ecMetaDir, bucket, objname), meta)
+}
+
+// fetches a slice from another target into a local file; returns nil meta if not found
+func (t *targetrunner) ecreceive(si *ServerInfo, bucket, objname, tmpfqn string) (*ecmeta, error) {
+	url := si.DirectURL + "/" + Rversion + "/" + Rslices + "/" + bucket + "/" + objname
+	response, err := t.dataclient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		ioutil.ReadAll(response.Body)
+		return nil, nil
+	}
+	meta := &ecmeta{}
+	if err = json.Unmarshal([]byte(response.Header.Get(HeaderECMeta)), meta); err != nil {
+		return nil, err
+	}
+	if _, err = ReceiveFile(tmpfqn, response); err != nil {
+		os.Remove(tmpfqn)
+		return nil, err
+	}
+	return meta, nil
+}
+
+func ecdecode(slices []*ecslice, meta *ecmeta, fqn string) (err error) {
+	codec, err := newRScodec(meta.Data, meta.Parity)
+	if err != nil {
+		return err
+	}
+	files := make([]*os.File, meta.Data+meta.Parity)
+	defer func() {
+		for _, file := range files {
+			if file != nil {
+				file.Close()
+			}
+		}
+	}()
+	var cnt int
+	for _, slice := range slices {
+		id := slice.meta.SliceID
+		if id < 1 || id > len(files) || files[id-1] != nil ||
+			slice.meta.Size != meta.Size || slice.meta.Data != meta.Data {
+			continue
+		}
+		if files[id-1], err = os.Open(slice.fqn); err != nil {
+			return err
+		}
+		cnt++
+	}
+	if cnt < meta.Data {
+		return fmt.Errorf("Too few slices to restore: %d (expecting at least %d)", cnt, meta.Data)
+	}
+	if err = CreateDir(filepath.Dir(fqn)); err != nil {
+		return err
+	}
+	out, err := os.Create(fqn)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	slicesize := ecslicesize(meta.Size, meta.Data)
+	bufs := make([][]byte, len(files))
+	for off := int64(0); off < slicesize; off += ecChunkSize {
+		chunk := int64(ecChunkSize)
+		if off+chunk > slicesize {
+			chunk = slicesize - off
+		}
+		for i, file := range files {
+			bufs[i] = nil
+			if file == nil {
+				continue
+			}
+			bufs[i] = make([]byte, chunk) // data slices are not padded: zeros past the end
+			if _, err = file.ReadAt(bufs[i], off); err != nil && err != io.EOF {
+				return err
+			}
+		}
+		if err = codec.reconstruct(bufs); err != nil {
+			return err
+		}
+		for i := 0; i < meta.Data; i++ {
+			objoff := int64(i)*slicesize + off
+			if objoff >= meta.Size {
+				break
+			}
+			b := bufs[i]
+			if objoff+int64(len(b)) > meta.Size {
+				b = b[:meta.Size-objoff]
+			}
+			if _, err = out.WriteAt(b, objoff); err != nil {
+				return err
+			}
+		}
+	}
+	return out.Truncate(meta.Size)
+}
+
+//===========================
+//
+// re-encoding upon Smap change
+//
+//===========================
+func (t *targetrunner) ecrebuild() {
+	x, err := xactreg.start(XactECRebuild)
+	if err != nil {
+		glog.Infoln(err)
+		return
+	}
+	defer xactreg.finish(x)
+	var reencoded int
+	for _, mountpath := range ctx.mountpaths {
+		if !mountpath.isenabled() {
+			continue
+		}
+		metadir := mountpath.Path + ecMetaDir
+		walk := func(metafqn string, osfi os.FileInfo, err error) error {
+			if x.aborted() {
+				return errXactAborted
+			}
+			if err != nil || osfi.Mode().IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(metadir, metafqn)
+			if err != nil {
+				return nil
+			}
+			split := strings.SplitN(rel, "/", 2)
+			if len(split) < 2 {
+				return nil
+			}
+			throttle(mountpath.Path)
+			x.add("objscanned", 1)
+			if t.ecrebuildone(split[0], split[1], metafqn) {
+				reencoded++
+				x.add("objreencoded", 1)
+			}
+			return nil
+		}
+		if _, err := os.Stat(metadir); err == nil {
+			filepath.Walk(metadir, walk)
+		}
+	}
+	glog.Infof("ecrebuild done, re-encoded %d objects", reencoded)
+}
+
+func (t *targetrunner) ecrebuildone(bucket, objname, metafqn string) bool {
+	meta, err := readecmeta(metafqn)
+	if err != nil {
+		glog.Errorf("Failed to read %q, err: %v", metafqn, err)
+		return false
+	}
+	fqn, _ := mirrorlookup(bucket, objname)
+	if fqn == "" {
+		os.Remove(metafqn) // the object is gone
+		return false
+	}
+	targets := t.ectargets(bucket, objname, meta.Data+meta.Parity)
+	changed := len(targets) != len(meta.Targets)
+	for i := 0; i < len(targets) && !changed; i++ {
+		changed = targets[i].DaemonID != meta.Targets[i]
+	}
+	if !changed {
+		return false
+	}
+	if err = t.ecencode(bucket, objname, fqn); err != nil {
+		glog.Errorf("Failed to re-encode %s/%s, err: %v", bucket, objname, err)
+		return false
+	}
+	// cleanup the slices that are no longer needed
+	for _, sid := range meta.Targets {
+		var keep bool
+		for _, si := range targets {
+			keep = keep || si.DaemonID == sid
+		}
+		if si := t.smap.get(sid); !keep && si != nil {
+			t.ecdelslice(si, bucket, objname)
+		}
+	}
+	return true
+}
+
+// removes the object's slices on all the targets that store them
+func (t *targetrunner) ecdelete(bucket, objname string) {
+	metafqn := ecfqn(ecMetaDir, bucket, objname)
+	meta, err := readecmeta(metafqn)
+	if err != nil {
+		return
+	}
+	for _, sid := range meta.Targets {
+		if si := t.smap.get(sid); si != nil {
+			t.ecdelslice(si, bucket, objname)
+		}
+	}
+	os.Remove(metafqn)
+}
+
+func (t *targetrunner) ecdelslice(si *ServerInfo, bucket, objname string) {
+	url := si.DirectURL + "/" + Rversion + "/" + Rslices + "/" + bucket + "/" + objname
+	if _, err := t.call(url, http.MethodDelete, nil); err != nil {
+		glog.Errorf("Failed to delete slice of %s/%s at %s, err: %v", bucket, objname, si.DaemonID, err)
+	}
+}
+
+//===========================
+//
+// http handler: "/"+Rversion+"/"+Rslices+"/"+bucket+"/"+objname
+//
+//===========================
+func (t *targetrunner) slicehdlr(w http.ResponseWriter, r *http.Request) {
+	apitems := t.restApiItems(r.URL.Path, 5)
+	if apitems = t.checkRestAPI(w, r, apitems, 2, Rversion, Rslices); apitems == nil {
+		return
+	}
+	bucket, objname := apitems[0], apitems[1]
+	fqn, metafqn := ecfqn(ecSliceDir, bucket, objname), ecfqn(ecSliceMetaDir, bucket, objname)
+	switch r.Method {
+	case http.MethodGet:
+		slicefqn, meta := eclocalslice(bucket, objname)
+		if meta == nil {
+			http.Error(w, "slice not found", http.StatusNotFound)
+			return
+		}
+		file, err := os.Open(slicefqn)
+		if err != nil {
+			http.Error(w, "slice not found", http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+		jsbytes, err := json.Marshal(meta)
+		assert(err == nil, err)
+		w.Header().Set(HeaderECMeta, string(jsbytes))
+		if _, err = copyBuffer(w, file); err != nil {
+			glog.Errorf("Failed to send slice %q, err: %v", fqn, err)
+		}
+	case http.MethodPut:
+		meta := &ecmeta{}
+		if err := json.Unmarshal([]byte(r.Header.Get(HeaderECMeta)), meta); err != nil {
+			invalmsghdlr(w, r, fmt.Sprintf("Invalid slice metadata, err: %v", err))
+			return
+		}
+		tmpfqn := filepath.Dir(fqn) + "/." + filepath.Base(fqn) + ".tmp"
+		if err := ecsave(tmpfqn, fqn, r.Body); err != nil {
+			webinterror(w, fmt.Sprintf("Failed to store slice %q, err: %v", fqn, err))
+			return
+		}
+		if err := writeecmeta(metafqn, meta); err != nil {
+			webinterror(w, fmt.Sprintf("Failed to store slice metadata %q, err: %v", metafqn, err))
+		}
+	case http.MethodDelete:
+		os.Remove(fqn)
+		os.Remove(metafqn)
+	default:
+		invalhdlr(w, r)
+	}
+}
+
+func ecsave(tmpfqn, fqn string, body io.Reader) error {
+	if err := CreateDir(filepath.Dir(fqn)); err != nil {
+		return err
+	}
+	file, err := os.Create(tmpfqn)
+	if err != nil {
+		return err
+	}
+	if _, err = copyBuffer(file, body); err != nil {
+		file.Close()
+		os.Remove(tmpfqn)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(tmpfqn)
+		return err
+	}
+	if err = os.Rename(tmpfqn, fqn); err != nil {
+		return errors.New("Failed to rename " + tmpfqn + ": " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Systematic Reed-Solomon over GF(2^8): data slices are stored as is, parity slices
+// are computed with the encoding matrix derived from a Vandermonde matrix,
+// any <data> out of <data+parity> slices are sufficient to reconstruct the rest.
+
+const (
+	gfpoly    = 0x11d // x^8 + x^4 + x^3 + x^2 + 1
+	maxslices = 256
+)
+
+var (
+	gfexp [2 * 255]byte
+	gflog [256]int
+)
+
+type rscodec struct {
+	data   int
+	parity int
+	matrix [][]byte // (data+parity) x data, the top data x data is identity
+}
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfexp[i], gfexp[i+255] = byte(x), byte(x)
+		gflog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfpoly
+		}
+	}
+}
+
+func gfmul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfexp[gflog[a]+gflog[b]]
+}
+
+func gfinv(a byte) byte {
+	assert(a != 0)
+	return gfexp[255-gflog[a]]
+}
+
+func gfpow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfexp[(gflog[a]*n)%255]
+}
+
+func newRScodec(data, parity int) (*rscodec, error) {
+	if data < 1 || parity < 1 || data+parity > maxslices {
+		return nil, fmt.Errorf("Invalid erasure coding configuration: %d data, %d parity slices", data, parity)
+	}
+	n := data + parity
+	vm := make([][]byte, n)
+	for r := 0; r < n; r++ {
+		vm[r] = make([]byte, data)
+		for c := 0; c < data; c++ {
+			vm[r][c] = gfpow(byte(r), c)
+		}
+	}
+	top, err := gfinvert(vm[:data])
+	if err != nil {
+		return nil, err
+	}
+	return &rscodec{data: data, parity: parity, matrix: gfmatmul(vm, top)}, nil
+}
+
+// computes parity slices given data slices; all slices must be allocated and have the same size
+func (c *rscodec) encode(slices [][]byte) {
+	assert(len(slices) == c.data+c.parity)
+	for i := 0; i < c.parity; i++ {
+		c.combine(c.matrix[c.data+i], slices[:c.data], slices[c.data+i])
+	}
+}
+
+// fills in the missing (nil) slices given at least c.data present ones
+func (c *rscodec) reconstruct(slices [][]byte) error {
+	assert(len(slices) == c.data+c.parity)
+	var (
+		rows    = make([][]byte, 0, c.data)
+		present = make([][]byte, 0, c.data)
+		size    int
+	)
+	for i, slice := range slices {
+		if slice == nil {
+			continue
+		}
+		if len(rows) < c.data {
+			rows = append(rows, c.matrix[i])
+			present = append(present, slice)
+		}
+		size = len(slice)
+	}
+	if len(rows) < c.data {
+		return fmt.Errorf("Too few slices to reconstruct: %d (expecting at least %d)", len(rows), c.data)
+	}
+	decode, err := gfinvert(rows)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < c.data; i++ {
+		if slices[i] != nil {
+			continue
+		}
+		slices[i] = make([]byte, size)
+		c.combine(decode[i], present, slices[i])
+	}
+	for i := c.data; i < c.data+c.parity; i++ {
+		if slices[i] != nil {
+			continue
+		}
+		slices[i] = make([]byte, size)
+		c.combine(c.matrix[i], slices[:c.data], slices[i])
+	}
+	return nil
+}
+
+// out = sum(coeffs[i] * in[i])
+func (c *rscodec) combine(coeffs []byte, in [][]byte, out []byte) {
+	for j := range out {
+		out[j] = 0
+	}
+	for i, coef := range coeffs {
+		if coef == 0 {
+			continue
+		}
+		src := in[i]
+		for j := range out {
+			out[j] ^= gfmul(coef, src[j])
+		}
+	}
+}
+
+//===========================
+//
+// GF(2^8) matrix helpers
+//
+//===========================
+func gfmatmul(a, b [][]byte) [][]byte {
+	out := make([][]byte, len(a))
+	for r := range a {
+		out[r] = make([]byte, len(b[0]))
+		for c := range b[0] {
+			var v byte
+			for i := range b {
+				v ^= gfmul(a[r][i], b[i][c])
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+// Gauss-Jordan elimination
+func gfinvert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+	for r := range m {
+		work[r] = make([]byte, 2*n)
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+	for c := 0; c < n; c++ {
+		if work[c][c] == 0 {
+			for r := c + 1; r < n; r++ {
+				if work[r][c] != 0 {
+					work[c], work[r] = work[r], work[c]
+					break
+				}
+			}
+		}
+		if work[c][c] == 0 {
+			return nil, errors.New("Singular matrix")
+		}
+		if inv := gfinv(work[c][c]); inv != 1 {
+			for i := range work[c] {
+				work[c][i] = gfmul(work[c][i], inv)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			f := work[r][c]
+			for i := range work[r] {
+				work[r][i] ^= gfmul(f, work[c][i])
+			}
+		}
+	}
+	out := make([][]byte, n)
+	for r := range work {
+		out[r] = work[r][n:]
+	}
+	return out, nil
+}
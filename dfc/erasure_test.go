@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Reed-Solomon encode/reconstruct with all combinations of lost slices,
+// the file-based (chunked) parity/decode round trip, restoring from the local slice,
+// failing the PUT that cannot be erasure-coded.
+//
+// Example run:
+// 	go test -v -run=erasure
+//
+package dfc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_erasure(t *testing.T) {
+	const data, parity, size = 4, 2, 1000
+	codec, err := newRScodec(data, parity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := make([][]byte, data+parity)
+	for i := range orig {
+		orig[i] = make([]byte, size)
+		if i < data {
+			rand.Read(orig[i])
+		}
+	}
+	codec.encode(orig)
+	for i := 0; i < data+parity; i++ {
+		for j := i + 1; j < data+parity; j++ {
+			slices := make([][]byte, data+parity)
+			for k := range slices {
+				if k != i && k != j {
+					slices[k] = append([]byte{}, orig[k]...)
+				}
+			}
+			if err := codec.reconstruct(slices); err != nil {
+				t.Fatalf("Failed to reconstruct w/o slices %d and %d, err: %v", i, j, err)
+			}
+			for k := range slices {
+				if !bytes.Equal(slices[k], orig[k]) {
+					t.Fatalf("Slice %d differs after reconstructing w/o slices %d and %d", k, i, j)
+				}
+			}
+		}
+	}
+	slices := make([][]byte, data+parity)
+	copy(slices, orig[:data-1])
+	if err := codec.reconstruct(slices); err == nil {
+		t.Error("Expected reconstruction to fail with too few slices")
+	}
+}
+
+func Test_erasurefile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erasure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// not a multiple of the data slice count and larger than a single chunk
+	size := int64(3*ecChunkSize + 12345)
+	obj := make([]byte, size)
+	rand.Read(obj)
+	fqn := filepath.Join(dir, "obj")
+	if err = ioutil.WriteFile(fqn, obj, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta := &ecmeta{Size: size, Data: 3, Parity: 2}
+	file, err := os.Open(fqn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parityfqns, err := ecparity(file, meta, fqn)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lose data slices #1 and #3: keep data slice #2 and both parities
+	slicesize := ecslicesize(size, meta.Data)
+	slicefqn := filepath.Join(dir, "slice2")
+	if err = ioutil.WriteFile(slicefqn, obj[slicesize:2*slicesize], 0644); err != nil {
+		t.Fatal(err)
+	}
+	slices := []*ecslice{{meta: &ecmeta{Size: size, Data: 3, Parity: 2, SliceID: 2}, fqn: slicefqn}}
+	for i, pfqn := range parityfqns {
+		slices = append(slices, &ecslice{meta: &ecmeta{Size: size, Data: 3, Parity: 2, SliceID: 4 + i}, fqn: pfqn})
+	}
+	outfqn := filepath.Join(dir, "restored")
+	if err = ecdecode(slices, meta, outfqn); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ioutil.ReadFile(outfqn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, obj) {
+		t.Errorf("Restored object differs: size %d (expecting %d)", len(restored), size)
+	}
+}
+
+func Test_erasureslices(t *testing.T) {
+	smap := &Smap{Smap: make(map[string]*ServerInfo)}
+	for i := 0; i < 5; i++ {
+		sid := "target" + string(rune('a'+i))
+		smap.Smap[sid] = &ServerInfo{DaemonID: sid}
+	}
+	hrw := hrwTargets("bucket/obj", smap, 5)
+	for _, sliceid := range []func(i int) int{func(i int) int { return i + 1 }, func(i int) int { return 0 }} {
+		slices := make([]*ecslice, 0, len(hrw))
+		for i, sid := range hrw {
+			slices = append(slices, &ecslice{meta: &ecmeta{SliceID: sliceid(i)}, sid: sid})
+		}
+		rand.Shuffle(len(slices), func(i, j int) { slices[i], slices[j] = slices[j], slices[i] })
+		ecsortslices(slices, "bucket/obj", smap)
+		for i, slice := range slices {
+			if slice.sid != hrw[i] || slice.meta.SliceID != sliceid(i) {
+				t.Errorf("Position %d: expected %s (slice %d), got %s (slice %d)", i, hrw[i], sliceid(i), slice.sid, slice.meta.SliceID)
+			}
+		}
+	}
+}
+
+func Test_erasurelocalslice(t *testing.T) {
+	savedmp := ctx.mountpaths
+	defer func() { ctx.mountpaths = savedmp }()
+	dir := testmountpaths(t, 3) // see mirror_test.go
+	defer os.RemoveAll(dir)
+
+	// the replica was received when another mountpath was the object's HRW one
+	hrw := hrwMpaths("lb/obj", 2)
+	slicefqn := hrw[1] + ecSliceDir + "/lb/obj"
+	if err := os.MkdirAll(filepath.Dir(slicefqn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(slicefqn, []byte("replica"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeecmeta(hrw[1]+ecSliceMetaDir+"/lb/obj", &ecmeta{Size: 7, Parity: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// no other targets
+	tr := &targetrunner{}
+	tr.si = &ServerInfo{DaemonID: "self"}
+	tr.smap = &Smap{Smap: map[string]*ServerInfo{"self": tr.si}}
+	fqn := hrw[0] + "/lb/obj"
+	if err := tr.ecrestore("lb", "obj", fqn); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(fqn); err != nil || string(b) != "replica" {
+		t.Errorf("Expected the object restored from the local replica, got %q, err: %v", b, err)
+	}
+	if _, err := os.Stat(slicefqn); err != nil {
+		t.Errorf("The local replica must be kept, err: %v", err)
+	}
+	meta, err := readecmeta(ecfqn(ecMetaDir, "lb", "obj"))
+	if err != nil || len(meta.Targets) != 1 || meta.Targets[0] != "self" {
+		t.Errorf("Unexpected metadata %+v, err: %v", meta, err)
+	}
+}
+
+func Test_erasureput(t *testing.T) {
+	savedmp, savedbmd, savedec := ctx.mountpaths, ctx.bmd, ctx.config.EC
+	defer func() { ctx.mountpaths, ctx.bmd, ctx.config.EC = savedmp, savedbmd, savedec }()
+	dir := testmountpaths(t, 1) // see mirror_test.go
+	defer os.RemoveAll(dir)
+	ctx.bmd = newbucketmd()
+	ctx.bmd.add("lb")
+	ctx.config.EC = ecconfig{Enabled: true, DataSlices: 2, ParitySlices: 1}
+
+	// a single target: nowhere to send the slices
+	tr := &targetrunner{}
+	tr.si = &ServerInfo{DaemonID: "self"}
+	tr.smap = &Smap{Smap: map[string]*ServerInfo{"self": tr.si}}
+	tr.statsif = newstatsregistry()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/"+Rversion+"/"+Rfiles+"/lb/obj", bytes.NewReader([]byte("data")))
+	tr.putlocal(w, r, "lb", "obj")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected the PUT to fail, got %d", w.Code)
+	}
+}
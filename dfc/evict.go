@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Explicit eviction: removes the cached copies (including local mirrors) of the listed
+// objects, of the objects with a given prefix or matching a regex, or of the entire
+// cloud bucket - on all targets and without touching the cloud. Pinned objects are kept.
+
+// PUT '{"action": "evict", "param1": bucket, "value": {EvictMsg}}' /v1/cluster
+// PUT '{"action": "evict", "value": {EvictMsg}}' /v1/files/bucket
+// NOTE: at most one of the three; none - the entire bucket
+type EvictMsg struct {
+	Objnames []string `json:"objnames,omitempty"`
+	Prefix   string   `json:"prefix,omitempty"`
+	Regex    string   `json:"regex,omitempty"` // matches the object names
+}
+
+// response: daemon ID => EvictStats (206 Partial Content if any target failed)
+type EvictStats struct {
+	Filesevicted int64  `json:"filesevicted"`
+	Bytesevicted int64  `json:"bytesevicted"`
+	Error        string `json:"error,omitempty"` // the target failed to evict
+}
+
+const evicttimeout = 10 * time.Minute // max time for a target to evict (e.g., the entire bucket)
+
+func parseevictmsg(msg *ActionMsg) (evictmsg *EvictMsg, re *regexp.Regexp, err error) {
+	evictmsg = &EvictMsg{}
+	if len(msg.Value) > 0 {
+		if err = json.Unmarshal(msg.Value, evictmsg); err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse %s value %s, err: %v", msg.Action, string(msg.Value), err)
+		}
+	}
+	n := 0
+	if len(evictmsg.Objnames) > 0 {
+		n++
+	}
+	if evictmsg.Prefix != "" {
+		n++
+	}
+	if evictmsg.Regex != "" {
+		n++
+		if re, err = regexp.Compile(evictmsg.Regex); err != nil {
+			return nil, nil, fmt.Errorf("Invalid %s regex %q, err: %v", msg.Action, evictmsg.Regex, err)
+		}
+	}
+	if msg.Param1 == "" || n > 1 {
+		return nil, nil, fmt.Errorf("Invalid %s: bucket %q, %+v (expecting bucket and at most one of objnames, prefix, regex)",
+			msg.Action, msg.Param1, *evictmsg)
+	}
+	return
+}
+
+//===========================
+//
+// proxy
+//
+//===========================
+func (p *proxyrunner) evict(w http.ResponseWriter, r *http.Request, msg *ActionMsg) {
+	if _, _, err := parseevictmsg(msg); err != nil {
+		p.statsif.add("numerr", 1)
+		invalmsghdlr(w, r, err.Error())
+		return
+	}
+	if _, bucket := parsebucket(msg.Param1); ctx.bmd.islocal(bucket) {
+		s := fmt.Sprintf("Cannot evict local bucket %s: its objects are not cached", bucket)
+		p.statsif.add("numerr", 1)
+		invalmsghdlr(w, r, s)
+		return
+	}
+	results, errs := p.broadcast(msg, evicttimeout)
+	out := make(map[string]*EvictStats, len(results)+len(errs))
+	for sid, outjson := range results {
+		stats := &EvictStats{}
+		if err := json.Unmarshal(outjson, stats); err != nil {
+			glog.Errorf("Failed to parse %s response from %s, err: %v", msg.Action, sid, err)
+		}
+		out[sid] = stats
+	}
+	for sid, s := range errs {
+		out[sid] = &EvictStats{Error: s}
+	}
+	jsbytes, err := json.Marshal(out)
+	assert(err == nil, err)
+	w.Header().Set("Content-Type", "application/json")
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	w.Write(jsbytes)
+}
+
+//===========================
+//
+// target
+//
+//===========================
+
+// PUT '{"action": "evict", "param1": bucket, "value": {EvictMsg}}' /v1/daemon
+func (t *targetrunner) evictaction(w http.ResponseWriter, r *http.Request, msg *ActionMsg) {
+	evictmsg, re, err := parseevictmsg(msg)
+	if err != nil {
+		t.statsif.add("numerr", 1)
+		invalmsghdlr(w, r, err.Error())
+		return
+	}
+	bucket := cachebucket(parsebucket(msg.Param1))
+	stats := evictobjs(bucket, evictmsg, re)
+	glog.Infof("%s %s %+v: evicted %d files, %d bytes", msg.Action, msg.Param1, *evictmsg,
+		stats.Filesevicted, stats.Bytesevicted)
+	s := getstorstats()
+	s.add("bytesevicted", stats.Bytesevicted)
+	bucketstats.add(bucket, "bytesevicted", stats.Bytesevicted)
+	s.add("filesevicted", stats.Filesevicted)
+	jsbytes, err := json.Marshal(stats)
+	assert(err == nil, err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsbytes)
+}
+
+func evictobjs(bucket string, evictmsg *EvictMsg, re *regexp.Regexp) *EvictStats {
+	stats := &EvictStats{}
+	// 1. the list: the object and its local copies
+	if len(evictmsg.Objnames) > 0 {
+		for _, objname := range evictmsg.Objnames {
+			if pinned.ispinned(bucket, objname) {
+				continue
+			}
+			for _, fqn := range mirrorfqns(bucket, objname) {
+				evictfile(fqn, bucket+"/"+objname, stats)
+			}
+		}
+		return stats
+	}
+	// 2. prefix, regex or the entire bucket: walk the bucket on all mountpaths
+	prefix := evictmsg.Prefix
+	for _, mountpath := range ctx.mountpaths {
+		dir := filepath.Join(mountpath.Path, bucket)
+		walk := func(fqn string, osfi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				glog.Errorf("Failed to walk %q, err: %v", fqn, err)
+				return err
+			}
+			if fqn == dir {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, fqn)
+			if err != nil {
+				return nil
+			}
+			if strings.HasPrefix(osfi.Name(), ".") { // system files and directories
+				if osfi.Mode().IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if osfi.Mode().IsDir() {
+				// skip the directories that cannot contain the prefixed objects
+				if prefix != "" && !strings.HasPrefix(rel+"/", prefix) && !strings.HasPrefix(prefix, rel+"/") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if prefix != "" && !strings.HasPrefix(rel, prefix) {
+				return nil
+			}
+			if re != nil && !re.MatchString(rel) {
+				return nil
+			}
+			if !pinned.ispinned(bucket, rel) {
+				evictfile(fqn, bucket+"/"+rel, stats)
+			}
+			return nil
+		}
+		if err := filepath.Walk(dir, walk); err != nil {
+			glog.Errorf("Failed to traverse %q, err: %v", dir, err)
+		}
+	}
+	return stats
+}
+
+func evictfile(fqn, objkey string, stats *EvictStats) {
+	finfo, err := os.Stat(fqn)
+	if err != nil {
+		return
+	}
+	if err = os.Remove(fqn); err != nil {
+		glog.Errorf("Failed to evict %q, err: %v", fqn, err)
+		return
+	}
+	if r := getatimerunner(); r != nil {
+		r.remove(fqn)
+	}
+	evictor.removed(objkey)
+	stats.Filesevicted++
+	stats.Bytesevicted += finfo.Size()
+}
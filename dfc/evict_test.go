@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Explicit eviction: EvictMsg validation, eviction by prefix, by regex, and of the entire bucket;
+// the proxy's per-target results.
+//
+// Example run:
+// 	go test -v -run=evict
+//
+package dfc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func Test_evictobjs(t *testing.T) {
+	for _, value := range []string{`{"prefix": "a", "regex": "b"}`, `{"regex": "("}`, `{"objnames": 1}`} {
+		if _, _, err := parseevictmsg(&ActionMsg{Action: ActionEvict, Param1: "bucket", Value: []byte(value)}); err == nil {
+			t.Errorf("Expected an error parsing %s", value)
+		}
+	}
+	if _, _, err := parseevictmsg(&ActionMsg{Action: ActionEvict}); err == nil {
+		t.Error("Expected an error: missing bucket")
+	}
+
+	mpath, err := ioutil.TempDir("", "evict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mpath)
+	saved, savedevictor := ctx.mountpaths, evictor
+	defer func() { ctx.mountpaths, evictor = saved, savedevictor }()
+	ctx.mountpaths = map[string]*mountPath{mpath: {Path: mpath}}
+	gdsf := &gdsfpolicy{objs: make(map[string]*gdsfobj)}
+	evictor = gdsf
+	gdsf.access("bucket/a/1.tar", 4, false)
+	gdsf.access("bucket/b/4.txt", 4, false)
+	for _, objname := range []string{"a/1.tar", "a/2.tar", "ab/3.tar", "b/4.txt", "5.txt", ".hidden"} {
+		fqn := filepath.Join(mpath, "bucket", objname)
+		if err = os.MkdirAll(filepath.Dir(fqn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(fqn, []byte("1234"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := evictobjs("bucket", &EvictMsg{Prefix: "a/"}, nil); stats.Filesevicted != 2 || stats.Bytesevicted != 8 {
+		t.Errorf("Prefix: unexpected %+v", *stats)
+	}
+	if len(gdsf.objs) != 1 || gdsf.objs["bucket/b/4.txt"] == nil {
+		t.Errorf("Expected the policy state of the evicted objects dropped, got %+v", gdsf.objs)
+	}
+	re := regexp.MustCompile(`\.txt$`)
+	if stats := evictobjs("bucket", &EvictMsg{Regex: re.String()}, re); stats.Filesevicted != 2 {
+		t.Errorf("Regex: unexpected %+v", *stats)
+	}
+	if stats := evictobjs("bucket", &EvictMsg{}, nil); stats.Filesevicted != 1 {
+		t.Errorf("Bucket: unexpected %+v", *stats)
+	}
+	if _, err = os.Stat(filepath.Join(mpath, "bucket", ".hidden")); err != nil {
+		t.Errorf("Expected system files to stay, err: %v", err)
+	}
+}
+
+func Test_evictcluster(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"filesevicted": 2, "bytesevicted": 8}`))
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	savedsmap, savedbmd := ctx.smap, ctx.bmd
+	defer func() { ctx.smap, ctx.bmd = savedsmap, savedbmd }()
+	ctx.bmd = newbucketmd()
+	ctx.smap = &Smap{Smap: map[string]*ServerInfo{
+		"t1":  {DaemonID: "t1", DirectURL: good.URL},
+		"t2":  {DaemonID: "t2", DirectURL: good.URL},
+		"bad": {DaemonID: "bad", DirectURL: bad.URL},
+	}}
+	p := &proxyrunner{}
+	p.httpclient = &http.Client{}
+	p.statsif = newstatsregistry()
+	w := httptest.NewRecorder()
+	p.evict(w, httptest.NewRequest(http.MethodPut, "/"+Rversion+"/"+Rcluster, nil),
+		&ActionMsg{Action: ActionEvict, Param1: "bucket"})
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("Expected %d, got %d", http.StatusPartialContent, w.Code)
+	}
+	out := make(map[string]*EvictStats)
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%v [%s]", err, w.Body.String())
+	}
+	if len(out) != 3 || out["t1"].Filesevicted != 2 || out["t2"].Bytesevicted != 8 || out["t1"].Error != "" {
+		t.Errorf("Unexpected per-target results %+v", out)
+	}
+	if out["bad"] == nil || out["bad"].Error == "" {
+		t.Errorf("Expected the failed target reported, got %+v", out["bad"])
+	}
+}
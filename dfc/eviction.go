@@ -0,0 +1,325 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Eviction policies: all_LRU/one_LRU walk the mountpaths and push candidates
+// into the max-heap ordered by the policy-computed key (lower keys are evicted first,
+// ties are broken by the usetime). Policies observe accesses via evictor.access()
+// that the target calls upon every GET of a cloud object, and drop the per-object state
+// via evictor.removed() when the object is removed by other means (expiry, the evict API).
+
+const (
+	EvictLRU  = "lru"  // least recently used (default)
+	EvictLFU  = "lfu"  // least frequently used
+	EvictGDSF = "gdsf" // greedy dual size frequency
+	EvictARC  = "arc"  // adaptive replacement cache
+)
+
+type evictpolicy interface {
+	name() string
+	// objkey is bucket/objname; hit is false when the object was just loaded from the cloud
+	access(objkey string, size int64, hit bool)
+	// start of an eviction pass
+	begin()
+	key(fi *fileinfo) float64
+	evicted(fi *fileinfo)
+	// the object is gone other than by the policy-ordered eviction
+	removed(objkey string)
+}
+
+var evictor evictpolicy = &lrupolicy{}
+
+func newevictpolicy(name string) (evictpolicy, error) {
+	switch name {
+	case "", EvictLRU:
+		return &lrupolicy{}, nil
+	case EvictLFU:
+		return &lfupolicy{}, nil
+	case EvictGDSF:
+		return &gdsfpolicy{objs: make(map[string]*gdsfobj)}, nil
+	case EvictARC:
+		return newarcpolicy(), nil
+	}
+	return nil, fmt.Errorf("Invalid eviction policy %q (expecting one of: %s, %s, %s, %s)",
+		name, EvictLRU, EvictLFU, EvictGDSF, EvictARC)
+}
+
+func evictsbefore(a, b *fileinfo) bool {
+	if a.overquota != b.overquota {
+		return a.overquota
+	}
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	return a.usetime.Before(b.usetime)
+}
+
+//===========================
+//
+// LRU: usetime only
+//
+//===========================
+type lrupolicy struct{}
+
+func (p *lrupolicy) name() string                               { return EvictLRU }
+func (p *lrupolicy) access(objkey string, size int64, hit bool) {}
+func (p *lrupolicy) begin()                                     {}
+func (p *lrupolicy) key(fi *fileinfo) float64                   { return 0 }
+func (p *lrupolicy) evicted(fi *fileinfo)                       {}
+func (p *lrupolicy) removed(objkey string)                      {}
+
+//===========================
+//
+// LFU: access count (tracked and persisted by the atimerunner), LRU within the same count
+//
+//===========================
+type lfupolicy struct{}
+
+func (p *lfupolicy) name() string                               { return EvictLFU }
+func (p *lfupolicy) access(objkey string, size int64, hit bool) {}
+func (p *lfupolicy) begin()                                     {}
+func (p *lfupolicy) key(fi *fileinfo) float64                   { return float64(fi.count) }
+func (p *lfupolicy) evicted(fi *fileinfo)                       {}
+func (p *lfupolicy) removed(objkey string)                      {}
+
+//===========================
+//
+// GDSF: H = L + frequency * cost / size, with the uniform cost = 1;
+// L (inflation) is the H of the most recently evicted object
+//
+//===========================
+type gdsfobj struct {
+	freq int64
+	h    float64
+}
+
+type gdsfpolicy struct {
+	sync.Mutex
+	objs map[string]*gdsfobj
+	l    float64
+}
+
+func (p *gdsfpolicy) name() string { return EvictGDSF }
+
+func (p *gdsfpolicy) access(objkey string, size int64, hit bool) {
+	if size < 1 {
+		size = 1
+	}
+	p.Lock()
+	o, ok := p.objs[objkey]
+	if !ok {
+		o = &gdsfobj{}
+		p.objs[objkey] = o
+	}
+	o.freq++
+	o.h = p.l + float64(o.freq)/float64(size)
+	p.Unlock()
+}
+
+func (p *gdsfpolicy) begin() {}
+
+func (p *gdsfpolicy) key(fi *fileinfo) float64 {
+	p.Lock()
+	defer p.Unlock()
+	if o, ok := p.objs[fi.objkey]; ok {
+		return o.h
+	}
+	size, freq := fi.size, fi.count
+	if size < 1 {
+		size = 1
+	}
+	if freq < 1 {
+		freq = 1
+	}
+	return p.l + float64(freq)/float64(size)
+}
+
+func (p *gdsfpolicy) evicted(fi *fileinfo) {
+	p.Lock()
+	if fi.key > p.l {
+		p.l = fi.key
+	}
+	p.Unlock()
+	p.removed(fi.objkey)
+}
+
+func (p *gdsfpolicy) removed(objkey string) {
+	p.Lock()
+	delete(p.objs, objkey)
+	p.Unlock()
+}
+
+//===========================
+//
+// ARC: T1 holds objects accessed once, T2 - more than once; B1 and B2 are the
+// respective ghost lists of the evicted objects. The target size of T1 (p) grows
+// upon a miss in B1 and shrinks upon a miss in B2. Each eviction pass evicts
+// from T1 first if it exceeds p, and from T2 first otherwise (LRU within each).
+//
+//===========================
+const arcghostmax = 64 * 1024
+
+type arcghost struct {
+	sizes map[string]int64
+	fifo  []string
+}
+
+type arcpolicy struct {
+	sync.Mutex
+	lists   map[string]int // objkey => 1 (T1) or 2 (T2)
+	sizes   map[string]int64
+	t1size  int64
+	t2size  int64
+	p       int64
+	b1, b2  arcghost
+	t1first bool
+}
+
+func newarcpolicy() *arcpolicy {
+	return &arcpolicy{
+		lists: make(map[string]int),
+		sizes: make(map[string]int64),
+		b1:    arcghost{sizes: make(map[string]int64)},
+		b2:    arcghost{sizes: make(map[string]int64)},
+	}
+}
+
+func (p *arcpolicy) name() string { return EvictARC }
+
+func (p *arcpolicy) access(objkey string, size int64, hit bool) {
+	p.Lock()
+	defer p.Unlock()
+	if !hit {
+		total := p.t1size + p.t2size + size
+		if _, ok := p.b1.sizes[objkey]; ok {
+			p.p += maxi64(1, int64(len(p.b2.sizes)/maxint(len(p.b1.sizes), 1))) * size
+			if p.p > total {
+				p.p = total
+			}
+			p.b1.remove(objkey)
+			p.insert(objkey, size, 2)
+			return
+		}
+		if _, ok := p.b2.sizes[objkey]; ok {
+			p.p -= maxi64(1, int64(len(p.b1.sizes)/maxint(len(p.b2.sizes), 1))) * size
+			if p.p < 0 {
+				p.p = 0
+			}
+			p.b2.remove(objkey)
+			p.insert(objkey, size, 2)
+			return
+		}
+		p.insert(objkey, size, 1)
+		return
+	}
+	// a hit promotes to T2; an untracked hit (e.g., after restart) implies a repeated access as well
+	p.insert(objkey, size, 2)
+}
+
+func (p *arcpolicy) insert(objkey string, size int64, list int) {
+	p.remove(objkey)
+	p.lists[objkey], p.sizes[objkey] = list, size
+	if list == 1 {
+		p.t1size += size
+	} else {
+		p.t2size += size
+	}
+}
+
+func (p *arcpolicy) remove(objkey string) (list int) {
+	list, ok := p.lists[objkey]
+	if !ok {
+		return 0
+	}
+	if list == 1 {
+		p.t1size -= p.sizes[objkey]
+	} else {
+		p.t2size -= p.sizes[objkey]
+	}
+	delete(p.lists, objkey)
+	delete(p.sizes, objkey)
+	return
+}
+
+func (p *arcpolicy) begin() {
+	p.Lock()
+	p.t1first = p.t1size > p.p
+	p.Unlock()
+}
+
+func (p *arcpolicy) key(fi *fileinfo) float64 {
+	p.Lock()
+	defer p.Unlock()
+	list := p.lists[fi.objkey]
+	if list == 0 {
+		list = 1 // untracked: not accessed since (re)start
+	}
+	if (list == 1) == p.t1first {
+		return 0
+	}
+	return 1
+}
+
+func (p *arcpolicy) evicted(fi *fileinfo) {
+	p.Lock()
+	defer p.Unlock()
+	size := fi.size
+	if p.remove(fi.objkey) == 2 {
+		p.b2.add(fi.objkey, size)
+	} else {
+		p.b1.add(fi.objkey, size)
+	}
+}
+
+// not an eviction: no ghost
+func (p *arcpolicy) removed(objkey string) {
+	p.Lock()
+	p.remove(objkey)
+	p.Unlock()
+}
+
+func (g *arcghost) add(objkey string, size int64) {
+	if _, ok := g.sizes[objkey]; !ok {
+		g.fifo = append(g.fifo, objkey)
+	}
+	g.sizes[objkey] = size
+	for len(g.sizes) > arcghostmax && len(g.fifo) > 0 {
+		delete(g.sizes, g.fifo[0])
+		g.fifo = g.fifo[1:]
+	}
+	if len(g.fifo) > 2*arcghostmax { // compact
+		fifo := make([]string, 0, len(g.sizes))
+		for _, name := range g.fifo {
+			if _, ok := g.sizes[name]; ok {
+				fifo = append(fifo, name)
+			}
+		}
+		g.fifo = fifo
+	}
+}
+
+// NOTE: leaves the name in the fifo - the latter is only used to bound the size
+func (g *arcghost) remove(objkey string) {
+	delete(g.sizes, objkey)
+}
+
+func maxi64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func maxint(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Eviction order of the LRU, LFU, GDSF and ARC policies given a fixed access pattern;
+// the per-object state dropped when the objects are removed.
+//
+// Example run:
+// 	go test -v -run=evictpolicy
+//
+package dfc
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func Test_evictpolicy(t *testing.T) {
+	now := time.Now()
+	// "hot" is the oldest but accessed the most; "big" is large and accessed twice
+	files := []*fileinfo{
+		{objkey: "b/hot", usetime: now.Add(-3 * time.Hour), size: 1000, count: 3},
+		{objkey: "b/once", usetime: now.Add(-time.Hour), size: 1000, count: 1},
+		{objkey: "b/big", usetime: now.Add(-2 * time.Hour), size: 100000, count: 2},
+	}
+	accesses := []string{"b/hot", "b/once", "b/big", "b/hot", "b/big", "b/hot"}
+	tests := []struct {
+		policy string
+		first  string
+	}{
+		{EvictLRU, "b/hot"},
+		{EvictLFU, "b/once"},
+		{EvictGDSF, "b/big"},
+		{EvictARC, "b/once"},
+	}
+	for _, test := range tests {
+		p, err := newevictpolicy(test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]bool)
+		for _, objkey := range accesses {
+			var size int64 = 1000
+			if objkey == "b/big" {
+				size = 100000
+			}
+			p.access(objkey, size, seen[objkey])
+			seen[objkey] = true
+		}
+		p.begin()
+		h := &maxheap{}
+		heap.Init(h)
+		for _, f := range files {
+			fi := *f
+			fi.key = p.key(&fi)
+			heap.Push(h, &fi)
+		}
+		if fi := heap.Pop(h).(*fileinfo); fi.objkey != test.first {
+			t.Errorf("%s: evicting %s first, expected %s", test.policy, fi.objkey, test.first)
+		}
+	}
+	if _, err := newevictpolicy("fifo"); err == nil {
+		t.Error("Expected an error for an unknown eviction policy")
+	}
+}
+
+func Test_evictpolicyremoved(t *testing.T) {
+	gdsf := &gdsfpolicy{objs: make(map[string]*gdsfobj)}
+	arc := newarcpolicy()
+	for _, p := range []evictpolicy{gdsf, arc} {
+		p.access("b/x", 100, false)
+		p.access("b/x", 100, true)
+		p.access("b/y", 100, false)
+		p.removed("b/x")
+		p.removed("b/none")
+	}
+	if len(gdsf.objs) != 1 || gdsf.objs["b/y"] == nil {
+		t.Errorf("gdsf: unexpected state %+v", gdsf.objs)
+	}
+	if len(arc.lists) != 1 || arc.lists["b/y"] != 1 || arc.t1size != 100 || arc.t2size != 0 {
+		t.Errorf("arc: unexpected state %+v, T1 %d, T2 %d", arc.lists, arc.t1size, arc.t2size)
+	}
+	if len(arc.b1.sizes) != 0 || len(arc.b2.sizes) != 0 {
+		t.Errorf("arc: removed objects are not ghosts, got %+v, %+v", arc.b1.sizes, arc.b2.sizes)
+	}
+}
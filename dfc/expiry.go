@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// TTL expiry: cached objects of a cloud bucket expire BucketProps.TTL (or, if not set,
+// Cache.ObjectTTL) after they were loaded. Expired objects are treated as stale on access
+// (see validcached) and, every Cache.ExpiryTime, removed by the XactExpiry xaction that
+// walks the mountpaths (see walkobj in lru.go). Pinned objects never expire.
+
+var lastexpiry time.Time // the last time the expiry job was started
+
+func init() {
+	storreg.counter("bytesexpired", "filesexpired")
+}
+
+// TTL of the bucket's cached objects, zero if they never expire
+func objttl(props *BucketProps, islocal bool) time.Duration {
+	if islocal {
+		return 0
+	}
+	if props.TTL > 0 {
+		return props.TTL
+	}
+	return ctx.config.Cache.ObjectTTL
+}
+
+func bucketttl(bucket string) time.Duration {
+	if ctx.bmd == nil { // *_test
+		return ctx.config.Cache.ObjectTTL
+	}
+	_, bucket = parsebucket(bucket) // see cachebucket
+	props := ctx.bmd.getprops(bucket)
+	return objttl(&props, ctx.bmd.islocal(bucket))
+}
+
+// NOTE: called by the stats runner only
+func expirydue() bool {
+	interval := ctx.config.Cache.ExpiryTime
+	return interval > 0 && time.Since(lastexpiry) >= interval
+}
+
+func startexpiry() error {
+	x, err := xactreg.start(XactExpiry)
+	if err != nil {
+		return err
+	}
+	lastexpiry = time.Now()
+	go allexpire(x)
+	return nil
+}
+
+func allexpire(x *xaction) {
+	defer xactreg.finish(x)
+	wg := &sync.WaitGroup{}
+	for _, mountpath := range ctx.mountpaths {
+		if !mountpath.isenabled() {
+			continue
+		}
+		wg.Add(1)
+		go oneexpire(mountpath.Path, wg, x)
+	}
+	wg.Wait()
+}
+
+func oneexpire(mpath string, wg *sync.WaitGroup, x *xaction) {
+	defer wg.Done()
+	var (
+		now                = time.Now()
+		r                  = getatimerunner()
+		fexpired, bexpired int64
+	)
+	cont := func() error {
+		if x.aborted() {
+			return errXactAborted
+		}
+		return nil
+	}
+	filter := func(bucket string) bool {
+		return bucketttl(bucket) > 0 && !pinned.isbucketpinned(bucket)
+	}
+	visit := func(fqn string, stat *syscall.Stat_t) error {
+		x.add("filesscanned", 1)
+		rel, err := filepath.Rel(mpath, fqn)
+		if err != nil {
+			return nil
+		}
+		split := strings.SplitN(rel, "/", 2)
+		if len(split) < 2 || pinned.ispinned(split[0], split[1]) {
+			return nil
+		}
+		mtime := time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec))
+		if now.Sub(mtime) <= bucketttl(split[0]) {
+			return nil
+		}
+		throttle(mpath)
+		if err := os.Remove(fqn); err != nil {
+			if !os.IsNotExist(err) {
+				glog.Errorf("Failed to remove expired %q, err: %v", fqn, err)
+			}
+			return nil
+		}
+		if r != nil {
+			r.remove(fqn)
+		}
+		evictor.removed(rel)
+		fexpired++
+		bexpired += stat.Size
+		x.add("filesexpired", 1)
+		return nil
+	}
+	err := filepath.Walk(mpath, func(fqn string, osfi os.FileInfo, err error) error {
+		return walkobj(fqn, osfi, err, cont, filter, visit)
+	})
+	if err != nil && err != errXactAborted {
+		glog.Errorf("Failed to traverse mpath %q, err: %v", mpath, err)
+	}
+	stats := getstorstats()
+	stats.add("bytesexpired", bexpired)
+	stats.add("filesexpired", fexpired)
+	glog.Infof("mpath %q: expired %d files, %d bytes", mpath, fexpired, bexpired)
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// TTL expiry: the expiry job removes the objects loaded more than TTL ago, keeps the rest;
+// the stats runner starts the job on idle targets too.
+//
+// Example run:
+// 	go test -v -run=expiry
+//
+package dfc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_expiry(t *testing.T) {
+	mpath, err := ioutil.TempDir("", "expiry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mpath)
+	savedmp, savedttl, savedevictor := ctx.mountpaths, ctx.config.Cache.ObjectTTL, evictor
+	defer func() { ctx.mountpaths, ctx.config.Cache.ObjectTTL, evictor = savedmp, savedttl, savedevictor }()
+	ctx.mountpaths = map[string]*mountPath{mpath: {Path: mpath}}
+	ctx.config.Cache.ObjectTTL = time.Hour
+	arc := newarcpolicy()
+	evictor = arc
+	arc.access("bucket/dir/old", 4, false)
+	arc.access("bucket/fresh", 4, false)
+
+	old, fresh := filepath.Join(mpath, "bucket", "dir", "old"), filepath.Join(mpath, "bucket", "fresh")
+	for _, fqn := range []string{old, fresh} {
+		if err = os.MkdirAll(filepath.Dir(fqn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(fqn, []byte("1234"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loaded := time.Now().Add(-2 * time.Hour)
+	if err = os.Chtimes(old, loaded, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := newxactions()
+	x, _ := reg.start(XactExpiry)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	oneexpire(mpath, wg, x)
+	reg.finish(x)
+
+	if _, err = os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected %q to expire", old)
+	}
+	if _, err = os.Stat(fresh); err != nil {
+		t.Errorf("Expected %q to stay, err: %v", fresh, err)
+	}
+	if len(arc.lists) != 1 || arc.lists["bucket/fresh"] == 0 {
+		t.Errorf("Expected the policy state of the expired object dropped, got %+v", arc.lists)
+	}
+	if p := x.getstatus().Progress; p["filesscanned"] != 2 || p["filesexpired"] != 1 {
+		t.Errorf("Unexpected progress %+v", p)
+	}
+}
+
+func Test_expiryidle(t *testing.T) {
+	savedmp, savedreg, savedttl, savedlast := ctx.mountpaths, xactreg, ctx.config.Cache.ExpiryTime, lastexpiry
+	defer func() {
+		ctx.mountpaths, xactreg, ctx.config.Cache.ExpiryTime, lastexpiry = savedmp, savedreg, savedttl, savedlast
+	}()
+	ctx.mountpaths = map[string]*mountPath{}
+	xactreg = newxactions()
+	ctx.config.Cache.ExpiryTime, lastexpiry = time.Hour, time.Time{}
+
+	// no counters changed since the previous invocation
+	r := &storstatsrunner{statsrunner: statsrunner{reg: newstatsregistry()}}
+	r.log()
+	r.log()
+	list := xactreg.list()
+	if len(list) != 1 || list[0].Kind != XactExpiry {
+		t.Fatalf("Expected the expiry job to start on an idle target, got %+v", list)
+	}
+	for i := 0; i < 100 && xactreg.isrunning(XactExpiry); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -35,6 +35,9 @@ func (obj *gcpif) listbucket(w http.ResponseWriter, bucket string) error {
 	if err != nil {
 		glog.Fatal(err)
 	}
+	// the iterator fetches the pages as needed; the entire listing is collected before
+	// responding, so that a failure in the middle is not mistaken for a shorter list
+	names := make([]string, 0, 1000)
 	it := client.Bucket(bucket).Objects(ctx, nil)
 	for {
 		attrs, err := it.Next()
@@ -45,7 +48,10 @@ func (obj *gcpif) listbucket(w http.ResponseWriter, bucket string) error {
 			errstr := fmt.Sprintf("Failed to get bucket objects, err: %v", err)
 			return webinterror(w, errstr)
 		}
-		fmt.Fprintln(w, attrs.Name)
+		names = append(names, attrs.Name)
+	}
+	for _, name := range names {
+		fmt.Fprintln(w, name)
 	}
 	return nil
 }
@@ -88,5 +94,7 @@ func (obj *gcpif) getobj(w http.ResponseWriter, fqn, bucket, objname string) (fi
 
 	stats := getstorstats()
 	stats.add("bytesloaded", bytes)
+	stats.addcloud(googlecloud, "bytesloaded", bytes)
+	bucketstats.add(cachebucket(googlecloud, bucket), "bytesloaded", bytes)
 	return file, nil
 }
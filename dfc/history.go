@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats history: every History.Resolution the stats runner takes a snapshot of the registry's
+// counters and gauges and keeps the last History.Retention worth of snapshots in a ring buffer.
+//
+// GET '{"what": "history", "param1": "numget,bytesloaded", "param2": "10m"}' returns the time series
+// of the named stats (param1, comma-separated; all if omitted) over the given period (param2; all
+// retained if omitted), along with the per-interval deltas and rates of the counters.
+// /v1/daemon: the target's history; /v1/cluster: the proxy's and all targets'.
+
+const (
+	historyretention = time.Hour
+	historyminsize   = 2
+)
+
+type statssnapshot struct {
+	time     time.Time
+	counters map[string]int64
+}
+
+type statshistory struct {
+	sync.Mutex
+	resolution time.Duration
+	ring       []statssnapshot
+	next       int  // the next slot to write
+	full       bool // wrapped around
+}
+
+// GET '{"what": "history"}' response
+type Statshistory struct {
+	Resolution time.Duration `json:"resolution"`
+	Samples    []Statssample `json:"samples"`
+}
+
+type Statssample struct {
+	Time   time.Time          `json:"time"`
+	Values map[string]int64   `json:"values"`           // counters and gauges
+	Deltas map[string]int64   `json:"deltas,omitempty"` // counters: change since the previous sample
+	Rates  map[string]float64 `json:"rates,omitempty"`  // counters: change per second
+}
+
+func newstatshistory(resolution, retention time.Duration) *statshistory {
+	if resolution <= 0 {
+		resolution = ctx.config.StatsTime
+	}
+	if retention <= 0 {
+		retention = historyretention
+	}
+	n := int(retention / resolution)
+	if n < historyminsize {
+		n = historyminsize
+	}
+	return &statshistory{resolution: resolution, ring: make([]statssnapshot, n)}
+}
+
+func (h *statshistory) add(now time.Time, counters map[string]int64) {
+	h.Lock()
+	h.ring[h.next] = statssnapshot{time: now, counters: counters}
+	h.next++
+	if h.next == len(h.ring) {
+		h.next, h.full = 0, true
+	}
+	h.Unlock()
+}
+
+// oldest first
+func (h *statshistory) ordered() []statssnapshot {
+	h.Lock()
+	defer h.Unlock()
+	if !h.full {
+		return append([]statssnapshot(nil), h.ring[:h.next]...)
+	}
+	out := make([]statssnapshot, 0, len(h.ring))
+	out = append(out, h.ring[h.next:]...)
+	return append(out, h.ring[:h.next]...)
+}
+
+// the samples taken after since (all if zero); names filters the stats (all if empty)
+func (h *statshistory) query(reg *statsregistry, names []string, since time.Time) *Statshistory {
+	out := &Statshistory{Resolution: h.resolution, Samples: []Statssample{}}
+	var prev *statssnapshot
+	snapshots := h.ordered()
+	for i := range snapshots {
+		snap := &snapshots[i]
+		if snap.time.Before(since) {
+			prev = snap
+			continue
+		}
+		sample := Statssample{Time: snap.time, Values: make(map[string]int64)}
+		if prev != nil {
+			sample.Deltas, sample.Rates = make(map[string]int64), make(map[string]float64)
+		}
+		for name, val := range snap.counters {
+			if len(names) > 0 && !contains(names, name) {
+				continue
+			}
+			sample.Values[name] = val
+			if prev == nil || reg.metrics[name].kind != statsCounter {
+				continue
+			}
+			delta := val - prev.counters[name]
+			sample.Deltas[name] = delta
+			if secs := snap.time.Sub(prev.time).Seconds(); secs > 0 {
+				sample.Rates[name] = float64(delta) / secs
+			}
+		}
+		out.Samples = append(out.Samples, sample)
+		prev = snap
+	}
+	return out
+}
+
+// GetMsg => stats names and the start of the period
+func parsehistorymsg(msg *GetMsg) (names []string, since time.Time, err error) {
+	if msg.Param1 != "" {
+		names = strings.Split(msg.Param1, ",")
+	}
+	if msg.Param2 != "" {
+		var period time.Duration
+		if period, err = time.ParseDuration(msg.Param2); err != nil || period <= 0 {
+			err = fmt.Errorf("Invalid history period %q (expecting a duration, e.g. \"10m\")", msg.Param2)
+			return
+		}
+		since = time.Now().Add(-period)
+	}
+	return
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
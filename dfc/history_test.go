@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Stats history: the ring buffer, time series with deltas and rates, the query parameters,
+// the cluster history with the targets that fail.
+//
+// Example run:
+// 	go test -v -run=history
+//
+package dfc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_history(t *testing.T) {
+	reg := newstatsregistry()
+	reg.counter("numget")
+	reg.gauge("bytespinned")
+	h := newstatshistory(time.Second, 3*time.Second)
+	if len(h.ring) != 3 {
+		t.Fatalf("Expected 3 slots, got %d", len(h.ring))
+	}
+	start := time.Now().Add(-time.Minute)
+	for i := int64(0); i < 5; i++ {
+		reg.add("numget", 10*i)
+		reg.set("bytespinned", 100*i)
+		h.add(start.Add(time.Duration(i)*2*time.Second), reg.snapshot())
+	}
+	// numget: 0, 10, 30, 60, 100; the last 3 are retained
+	out := h.query(reg, nil, time.Time{})
+	if out.Resolution != time.Second || len(out.Samples) != 3 {
+		t.Fatalf("Unexpected history %+v", out)
+	}
+	first, last := out.Samples[0], out.Samples[2]
+	if first.Values["numget"] != 30 || first.Deltas != nil {
+		t.Errorf("Unexpected first sample %+v", first)
+	}
+	if last.Values["numget"] != 100 || last.Deltas["numget"] != 40 || last.Rates["numget"] != 20 {
+		t.Errorf("Unexpected last sample %+v", last)
+	}
+	if last.Values["bytespinned"] != 400 {
+		t.Errorf("Unexpected gauge in %+v", last)
+	}
+	if _, ok := last.Deltas["bytespinned"]; ok {
+		t.Errorf("Gauges are not expected to have deltas: %+v", last)
+	}
+
+	// the period and the names
+	out = h.query(reg, []string{"numget"}, start.Add(7*time.Second))
+	if len(out.Samples) != 1 || len(out.Samples[0].Values) != 1 || out.Samples[0].Deltas["numget"] != 40 {
+		t.Errorf("Unexpected history %+v", out)
+	}
+
+	names, since, err := parsehistorymsg(&GetMsg{What: GetHistory, Param1: "numget,numerr", Param2: "10m"})
+	if err != nil || len(names) != 2 || time.Since(since) < 10*time.Minute || time.Since(since) > 11*time.Minute {
+		t.Errorf("Unexpected %v, %v, %v", names, since, err)
+	}
+	for _, period := range []string{"10", "-1m", "abc"} {
+		if _, _, err := parsehistorymsg(&GetMsg{What: GetHistory, Param2: period}); err == nil {
+			t.Errorf("Expected an error parsing period %q", period)
+		}
+	}
+}
+
+func Test_historycluster(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"samples": []}`))
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	savedsmap, savedrg := ctx.smap, ctx.rg
+	defer func() { ctx.smap, ctx.rg = savedsmap, savedrg }()
+	ctx.smap = &Smap{Smap: map[string]*ServerInfo{
+		"good": {DaemonID: "good", DirectURL: good.URL},
+		"bad":  {DaemonID: "bad", DirectURL: bad.URL},
+	}}
+	ctx.rg = &rungroup{runmap: map[string]runner{
+		xproxystats: &proxystatsrunner{statsrunner: statsrunner{reg: proxyreg}}}}
+	p := &proxyrunner{}
+	p.httpclient = &http.Client{}
+	p.statsif = newstatsregistry()
+	w := httptest.NewRecorder()
+	p.httpcluget(w, httptest.NewRequest(http.MethodGet, "/"+Rversion+"/"+Rcluster, strings.NewReader(`{"what": "history"}`)))
+	var out struct {
+		Targets map[string]json.RawMessage `json:"targets"`
+		Errors  map[string]string          `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%v [%s]", err, w.Body.String())
+	}
+	if len(out.Targets) != 1 || out.Targets["good"] == nil {
+		t.Errorf("Unexpected target history %+v", out.Targets)
+	}
+	if len(out.Errors) != 1 || out.Errors["bad"] == "" {
+		t.Errorf("Unexpected per-target errors %+v", out.Errors)
+	}
+}
@@ -5,6 +5,8 @@
 package dfc
 
 import (
+	"sort"
+
 	"github.com/OneOfOne/xxhash"
 )
 
@@ -25,9 +27,35 @@ func hrwTarget(name string) (sid string) {
 	}
 	return
 }
+
+// up to n targets from a given Smap in the descending HRW order
+func hrwTargets(name string, smap *Smap, n int) []string {
+	type targetcs struct {
+		sid string
+		cs  uint32
+	}
+	arr := make([]targetcs, 0, len(smap.Smap))
+	for id := range smap.Smap {
+		arr = append(arr, targetcs{id, xxhash.ChecksumString32S(name+id, LCG32)})
+	}
+	sort.Slice(arr, func(i, j int) bool { return arr[i].cs > arr[j].cs })
+	if n > len(arr) {
+		n = len(arr)
+	}
+	sids := make([]string, n)
+	for i := 0; i < n; i++ {
+		sids[i] = arr[i].sid
+	}
+	return sids
+}
+
+// NOTE: disabled mountpaths are skipped
 func hrwMpath(name string) (mpath string) {
 	var max uint32
-	for path, _ := range ctx.mountpaths {
+	for path, mountpath := range ctx.mountpaths {
+		if !mountpath.isenabled() {
+			continue
+		}
 		cs := xxhash.ChecksumString32S(name+path, LCG32)
 		if cs > max {
 			max = cs
@@ -36,3 +64,27 @@ func hrwMpath(name string) (mpath string) {
 	}
 	return
 }
+
+// up to n enabled mountpaths in the descending HRW order: the first one is always hrwMpath(name)
+func hrwMpaths(name string, n int) []string {
+	type mpathcs struct {
+		path string
+		cs   uint32
+	}
+	arr := make([]mpathcs, 0, len(ctx.mountpaths))
+	for path, mountpath := range ctx.mountpaths {
+		if !mountpath.isenabled() {
+			continue
+		}
+		arr = append(arr, mpathcs{path, xxhash.ChecksumString32S(name+path, LCG32)})
+	}
+	sort.Slice(arr, func(i, j int) bool { return arr[i].cs > arr[j].cs })
+	if n > len(arr) {
+		n = len(arr)
+	}
+	mpaths := make([]string, n)
+	for i := 0; i < n; i++ {
+		mpaths[i] = arr[i].path
+	}
+	return mpaths
+}
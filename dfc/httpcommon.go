@@ -34,6 +34,10 @@ const (
 	Rcluster  = "cluster"
 	Rdaemon   = "daemon"
 	Rsyncsmap = "syncsmap"
+	Rslices   = "slices" // erasure-coded slices, target to target
+	Rsyncbmd  = "syncbmd"
+	Rbuckets  = "buckets"
+	Rmetrics  = "metrics" // GET /metrics, see metrics.go
 )
 
 // FIXME: revisit the following 3 methods, and make consistent
@@ -87,6 +91,7 @@ type httprunner struct {
 	glogger    *log.Logger
 	si         *ServerInfo
 	httpclient *http.Client // http client for intra-cluster comm
+	dataclient *http.Client // ditto, for transferring data (no timeout)
 	statsif    statsif
 }
 
@@ -94,7 +99,7 @@ func (r *httprunner) registerhdlr(path string, handler func(http.ResponseWriter,
 	if r.mux == nil {
 		r.mux = http.NewServeMux()
 	}
-	r.mux.HandleFunc(path, handler)
+	r.mux.HandleFunc(path, r.tlshdlr(r.authhdlr(handler)))
 }
 
 func (r *httprunner) init(s statsif) error {
@@ -103,10 +108,12 @@ func (r *httprunner) init(s statsif) error {
 	if err != nil {
 		return err
 	}
-	// http client
-	r.httpclient = &http.Client{
-		Transport: &http.Transport{MaxIdleConnsPerHost: maxidleconns},
-		Timeout:   requesttimeout,
+	// http clients
+	if r.httpclient, err = newhttpclient(requesttimeout); err != nil {
+		return err
+	}
+	if r.dataclient, err = newhttpclient(0); err != nil {
+		return err
 	}
 	// init ServerInfo here
 	r.si = &ServerInfo{}
@@ -117,7 +124,7 @@ func (r *httprunner) init(s statsif) error {
 	split := strings.Split(ipaddr, ".")
 	cs := xxhash.ChecksumString32S(split[len(split)-1], LCG32)
 	r.si.DaemonID = strconv.Itoa(int(cs&0xffff)) + ":" + ctx.config.Listen.Port
-	r.si.DirectURL = "http://" + r.si.NodeIPAddr + ":" + r.si.DaemonPort
+	r.si.DirectURL = scheme() + "://" + r.si.NodeIPAddr + ":" + r.si.DaemonPort
 	return nil
 }
 
@@ -128,7 +135,17 @@ func (r *httprunner) run() error {
 
 	portstring := ":" + ctx.config.Listen.Port
 	r.h = &http.Server{Addr: portstring, Handler: r.mux, ErrorLog: r.glogger}
-	if err := r.h.ListenAndServe(); err != nil {
+	var err error
+	if ctx.config.TLS.Enabled {
+		if r.h.TLSConfig, err = servertlsconfig(); err != nil {
+			glog.Errorf("Failed to start %s, err: %v", r.name, err)
+			return err
+		}
+		err = r.h.ListenAndServeTLS(ctx.config.TLS.Certificate, ctx.config.TLS.Key)
+	} else {
+		err = r.h.ListenAndServe()
+	}
+	if err != nil {
 		if err != http.ErrServerClosed {
 			glog.Errorf("Terminated %s with err: %v", r.name, err)
 			return err
@@ -154,6 +171,22 @@ func (r *httprunner) stop(err error) {
 // optionally, sends a json-encoded content to the callee
 // expects only OK or FAIL in the return
 func (r *httprunner) call(url string, method string, injson []byte) (outjson []byte, err error) {
+	outjson, _, err = r.callstatus(url, method, injson, 0)
+	return
+}
+
+// same as call, with the given timeout (0: the client's default);
+// unlike call, fails if the callee responds with an HTTP error status
+func (r *httprunner) calltimeout(url string, method string, injson []byte, timeout time.Duration) (outjson []byte, err error) {
+	var status int
+	if outjson, status, err = r.callstatus(url, method, injson, timeout); err == nil && status >= http.StatusBadRequest {
+		err = fmt.Errorf("%s %s: %s (%s)", method, url, http.StatusText(status), strings.TrimSpace(string(outjson)))
+		outjson = nil
+	}
+	return
+}
+
+func (r *httprunner) callstatus(url string, method string, injson []byte, timeout time.Duration) (outjson []byte, status int, err error) {
 	var (
 		request  *http.Request
 		response *http.Response
@@ -174,23 +207,33 @@ func (r *httprunner) call(url string, method string, injson []byte) (outjson []b
 	}
 	if err != nil {
 		glog.Errorf("Unexpected failure to create http request %s %s, err: %v", method, url, err)
-		return nil, err
+		return nil, 0, err
+	}
+	client := r.httpclient
+	if timeout > 0 {
+		contextwith, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		request = request.WithContext(contextwith)
+		if timeout > requesttimeout && r.dataclient != nil {
+			client = r.dataclient // the context, rather than the client, times out
+		}
 	}
-	response, err = r.httpclient.Do(request)
+	response, err = client.Do(request)
 	if err != nil || response == nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer func() {
 		if response != nil {
 			err = response.Body.Close()
 		}
 	}()
+	status = response.StatusCode
 	// block until done (note: returned content is ignored and discarded)
 	if outjson, err = ioutil.ReadAll(response.Body); err != nil {
 		glog.Errorf("Failed to read http, err: %v", err)
-		return nil, err
+		return nil, status, err
 	}
-	return outjson, err
+	return outjson, status, err
 }
 
 //=============================
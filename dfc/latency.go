@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Latency histograms by operation (registered with the stats registry, see stats.go):
+// updated lock-free by the request handlers; the stats runners compute the per-interval
+// percentiles, and /metrics exports the histograms (see metrics.go).
+
+// operations
+const (
+	OpGetHit     = "gethit"     // target: GET served from the cache (or a local bucket)
+	OpColdGet    = "coldget"    // target: GET of a not yet cached object, total
+	OpCloudFetch = "cloudfetch" // target: download from the cloud
+	OpTTFB       = "ttfb"       // target: time to the first byte of the GET response
+	OpRedirect   = "redirect"   // proxy: GET redirect
+)
+
+// histogram buckets (upper bounds): up to half an hour - cold GETs and cloud downloads
+// of large objects take minutes
+var latencybuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+	30 * time.Second, time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+}
+
+type histogram struct {
+	counts []int64 // per bucket, non-cumulative; the last one is +Inf
+	count  int64
+	sum    int64 // ns
+}
+
+// per-interval latency, see storstatsrunner.log
+type Latencystats struct {
+	Count int64         `json:"count"`
+	Avg   time.Duration `json:"avg"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+}
+
+func init() {
+	storreg.latency(OpGetHit, OpColdGet, OpCloudFetch, OpTTFB)
+	proxyreg.latency(OpRedirect)
+}
+
+func newhistogram() *histogram {
+	return &histogram{counts: make([]int64, len(latencybuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(latencybuckets), func(i int) bool { return d <= latencybuckets[i] })
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() *histogram {
+	s := newhistogram()
+	for i := range h.counts {
+		s.counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	s.count, s.sum = atomic.LoadInt64(&h.count), atomic.LoadInt64(&h.sum)
+	return s
+}
+
+// the difference between two snapshots
+func (h *histogram) sub(prev *histogram) *histogram {
+	d := newhistogram()
+	for i := range h.counts {
+		d.counts[i] = h.counts[i] - prev.counts[i]
+	}
+	d.count, d.sum = h.count-prev.count, h.sum-prev.sum
+	return d
+}
+
+// estimates the q-th quantile by linear interpolation within the bucket
+// (the +Inf bucket is reported as its lower bound)
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := q * float64(h.count)
+	var cumulative int64
+	for i, n := range h.counts {
+		if n == 0 || float64(cumulative+n) < rank {
+			cumulative += n
+			continue
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = latencybuckets[i-1]
+		}
+		if i == len(latencybuckets) {
+			return lower
+		}
+		upper := latencybuckets[i]
+		return lower + time.Duration(float64(upper-lower)*(rank-float64(cumulative))/float64(n))
+	}
+	return latencybuckets[len(latencybuckets)-1]
+}
+
+func (h *histogram) stats() *Latencystats {
+	s := &Latencystats{Count: h.count, P50: h.quantile(0.5), P90: h.quantile(0.9), P99: h.quantile(0.99)}
+	if h.count > 0 {
+		s.Avg = time.Duration(h.sum / h.count)
+	}
+	return s
+}
+
+//===========================
+//
+// stats runners
+//
+//===========================
+
+// latency over the interval since the previous call, and the rates (per second) of the given counters
+func (r *statsrunner) interval(ratenames []string) (map[string]*Latencystats, map[string]float64) {
+	now := time.Now()
+	ops := r.reg.latencies()
+	lat := make(map[string]*Latencystats, len(ops))
+	if r.prevlat == nil {
+		r.prevlat = make(map[string]*histogram, len(ops))
+	}
+	for _, op := range ops {
+		cur := r.reg.metrics[op].hist.snapshot()
+		prev, ok := r.prevlat[op]
+		if !ok {
+			prev = newhistogram()
+		}
+		lat[op], r.prevlat[op] = cur.sub(prev).stats(), cur
+	}
+	counters := make(map[string]int64, len(ratenames))
+	for _, name := range ratenames {
+		counters[name] = r.reg.get(name)
+	}
+	rates := make(map[string]float64, len(counters))
+	if elapsed := now.Sub(r.prevtime).Seconds(); !r.prevtime.IsZero() && elapsed > 0 {
+		for name, val := range counters {
+			rates[name] = float64(val-r.prevcounters[name]) / elapsed
+		}
+	}
+	r.prevcounters, r.prevtime = counters, now
+	return lat, rates
+}
+
+func (r *statsrunner) loglatency(lat map[string]*Latencystats, rates map[string]float64) {
+	for _, op := range r.reg.latencies() {
+		if s := lat[op]; s.Count > 0 {
+			glog.Infof("%s latency %s: count,%d,avg,%v,p50,%v,p90,%v,p99,%v", r.name, op, s.Count, s.Avg, s.P50, s.P90, s.P99)
+		}
+	}
+	var active bool
+	for _, v := range rates {
+		active = active || v > 0
+	}
+	if active {
+		glog.Infof("%s rates (per second): %v", r.name, rates)
+	}
+}
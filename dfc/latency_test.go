@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Latency histograms: percentiles and per-interval latency and rates.
+//
+// Example run:
+// 	go test -v -run=latency
+//
+package dfc
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_latency(t *testing.T) {
+	h := newhistogram()
+	for i := 0; i < 90; i++ {
+		h.observe(1500 * time.Microsecond) // (1ms, 2ms]
+	}
+	for i := 0; i < 10; i++ {
+		h.observe(200 * time.Millisecond) // (100ms, 250ms]
+	}
+	s := h.snapshot().stats()
+	if s.Count != 100 || s.P50 <= time.Millisecond || s.P50 > 2*time.Millisecond ||
+		s.P99 <= 100*time.Millisecond || s.P99 > 250*time.Millisecond {
+		t.Errorf("Unexpected percentiles %+v", *s)
+	}
+	if s.Avg < 21*time.Millisecond || s.Avg > 22*time.Millisecond {
+		t.Errorf("Unexpected average %v", s.Avg)
+	}
+
+	// large objects: minutes, not the +Inf bucket
+	h = newhistogram()
+	for i := 0; i < 10; i++ {
+		h.observe(3 * time.Minute) // (2m, 5m]
+	}
+	if s = h.snapshot().stats(); s.P50 <= 2*time.Minute || s.P50 > 5*time.Minute {
+		t.Errorf("Unexpected percentiles %+v", *s)
+	}
+
+	// the interval since the previous call
+	reg := newstatsregistry()
+	reg.counter("numget")
+	reg.latency(OpGetHit)
+	r := &statsrunner{reg: reg}
+	reg.observe(OpGetHit, time.Millisecond)
+	reg.add("numget", 1)
+	r.interval([]string{"numget"})
+	r.prevtime = r.prevtime.Add(-2 * time.Second)
+	reg.observe(OpGetHit, 3*time.Millisecond)
+	reg.observe(OpGetHit, 3*time.Millisecond)
+	reg.add("numget", 8)
+	lat, rates := r.interval([]string{"numget"})
+	if lat[OpGetHit].Count != 2 || lat[OpGetHit].Avg != 3*time.Millisecond {
+		t.Errorf("Unexpected interval latency %+v", *lat[OpGetHit])
+	}
+	if rates["numget"] < 3.9 || rates["numget"] > 4 {
+		t.Errorf("Unexpected rate %v", rates["numget"])
+	}
+}
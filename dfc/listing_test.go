@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Local bucket listing: parallel queries, partial results with per-target errors.
+//
+// Example run:
+// 	go test -v -run=listlocalbucket
+//
+package dfc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_listlocalbucket(t *testing.T) {
+	names := func(list string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(list))
+		}))
+	}
+	t1, t2 := names("lb/c\nlb/a\n"), names("lb/b\n")
+	defer t1.Close()
+	defer t2.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	savedsmap := ctx.smap
+	defer func() { ctx.smap = savedsmap }()
+	p := &proxyrunner{}
+	p.httpclient = &http.Client{}
+	p.statsif = newstatsregistry()
+
+	list := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.listlocalbucket(w, httptest.NewRequest(http.MethodGet, "/"+Rversion+"/"+Rfiles+"/lb", nil), "lb")
+		return w
+	}
+	ctx.smap = &Smap{Smap: map[string]*ServerInfo{
+		"t1": {DaemonID: "t1", DirectURL: t1.URL},
+		"t2": {DaemonID: "t2", DirectURL: t2.URL},
+	}}
+	w := list()
+	if w.Code != http.StatusOK || w.Body.String() != "lb/a\nlb/b\nlb/c\n" || w.Header().Get(HeaderListErrors) != "" {
+		t.Errorf("Unexpected listing: %d %q %q", w.Code, w.Body.String(), w.Header().Get(HeaderListErrors))
+	}
+
+	ctx.smap.Smap["bad"] = &ServerInfo{DaemonID: "bad", DirectURL: bad.URL}
+	w = list()
+	if w.Code != http.StatusPartialContent || w.Body.String() != "lb/a\nlb/b\nlb/c\n" {
+		t.Errorf("Expected partial listing, got: %d %q", w.Code, w.Body.String())
+	}
+	errs := make(map[string]string)
+	if err := json.Unmarshal([]byte(w.Header().Get(HeaderListErrors)), &errs); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs["bad"] == "" {
+		t.Errorf("Unexpected per-target errors %+v", errs)
+	}
+}
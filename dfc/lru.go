@@ -10,7 +10,6 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -19,31 +18,32 @@ import (
 
 // types
 type fileinfo struct {
-	fqn     string
-	usetime time.Time
-	size    int64
-	index   int
+	fqn       string
+	objkey    string // bucket/objname
+	usetime   time.Time
+	size      int64
+	count     int64   // number of accesses, see atime.go
+	key       float64 // eviction policy key, see eviction.go
+	overquota bool    // the bucket is over its quota, see quota.go
+	index     int
 }
 
+// per mountpath: the concurrent one_LRU goroutines share no state
 type lructx struct {
+	mpath   string
+	h       *maxheap
 	cursize int64
 	totsize int64
-	newest  time.Time
+	last    *fileinfo       // the last to evict out of all the pushed so far
+	skip    map[string]bool // dry run: the indexed objects that were already considered
 }
 
 type maxheap []*fileinfo
 
-// vars
-var maxheapmap = make(map[string]*maxheap)
-var lructxmap = make(map[string]*lructx)
-var lrucas int64
-
-// FIXME: mountpath.enabled is never used
+// FIXME: mountpath.isenabled() is never used
+// NOTE: runs as the LRU job, see startlru() in lrujob.go
 func all_LRU() {
-	if !atomic.CompareAndSwapInt64(&lrucas, 0, 7890123) {
-		glog.Infoln("all_LRU is already running")
-		return
-	}
+	defer curlru.finish()
 	mntcnt := len(ctx.mountpaths)
 	fschkwg := &sync.WaitGroup{}
 	fsmap := make(map[syscall.Fsid]bool, mntcnt)
@@ -60,18 +60,11 @@ func all_LRU() {
 	}
 	fschkwg.Wait()
 	glog.Infoln("all_LRU done")
-	swapped := atomic.CompareAndSwapInt64(&lrucas, 7890123, 0)
-	assert(swapped)
 }
 
 func one_LRU(mpath string, fschkwg *sync.WaitGroup) error {
 	defer fschkwg.Done()
-	hwm := ctx.config.Cache.FSHighWaterMark
-	lwm := ctx.config.Cache.FSLowWaterMark
-
-	h := &maxheap{}
-	heap.Init(h)
-	maxheapmap[mpath] = h
+	lwm, hwm := curlru.watermarks()
 
 	statfs := syscall.Statfs_t{}
 	if err := syscall.Statfs(mpath, &statfs); err != nil {
@@ -88,32 +81,104 @@ func one_LRU(mpath string, fschkwg *sync.WaitGroup) error {
 	lwmblocks := blocks * uint64(lwm) / 100
 	toevict := int64(used-lwmblocks) * bsize
 
+	curlru.begin(mpath, toevict)
+	defer curlru.end(mpath)
+
 	// init LRU context
-	lructxmap[mpath] = &lructx{totsize: toevict}
-	defer func() { maxheapmap[mpath], lructxmap[mpath] = nil, nil }() // GC
+	h := &maxheap{}
+	heap.Init(h)
+	c := &lructx{mpath: mpath, h: h, totsize: toevict}
 
-	if err := filepath.Walk(mpath, walkfunc); err != nil {
+	evictor.begin()
+	// 1. use the access index, if available (see atime.go)
+	if r := getatimerunner(); r != nil {
+		if fis := r.snapshot(mpath); fis != nil {
+			curlru.scanned(int64(len(fis)))
+			for _, fi := range fis {
+				if bucketevictable(strings.SplitN(fi.objkey, "/", 2)[0]) {
+					lrupush(h, c, fi)
+				}
+			}
+			if toevict = do_LRU(toevict, c); toevict <= 0 || !curlru.checkpoint() {
+				return nil
+			}
+			glog.Infof("mpath %q: not enough indexed objects to evict, walking the filesystem", mpath)
+			h = &maxheap{}
+			heap.Init(h)
+			c = &lructx{mpath: mpath, h: h, totsize: toevict}
+			if curlru.dryrun() {
+				// nothing was removed: skip the indexed objects to not report them twice
+				c.skip = make(map[string]bool, len(fis))
+				for _, fi := range fis {
+					c.skip[fi.objkey] = true
+				}
+			}
+		}
+	}
+	// 2. walk the filesystem
+	if err := filepath.Walk(mpath, c.walkfunc); err != nil {
+		if err == errLRUAborted {
+			glog.Infof("mpath %q: %v", mpath, err)
+			return nil
+		}
 		glog.Errorf("Failed to traverse mpath %q, err: %v", mpath, err)
 		return err
 	}
+	if toevict = do_LRU(toevict, c); toevict > 0 {
+		glog.Errorf("Failed to reach lwm %d for mpath %q: rem-toevict %d", lwm, mpath, toevict)
+	}
+	return nil
+}
 
-	if err := do_LRU(toevict, mpath); err != nil {
-		glog.Errorf("Error do_LRU mpath %q, err: %v", mpath, err)
-		return err
+// local buckets and buckets with eviction disabled are never evicted
+func bucketevictable(bucket string) bool {
+	if ctx.bmd == nil { // *_test
+		return true
+	}
+	_, name := parsebucket(bucket) // see cachebucket
+	return !ctx.bmd.islocal(name) && !ctx.bmd.getprops(name).NoEviction && !pinned.isbucketpinned(bucket)
+}
+
+func (c *lructx) walkfunc(fqn string, osfi os.FileInfo, err error) error {
+	return walkobj(fqn, osfi, err, lrucontinue, bucketevictable, c.lruvisit)
+}
+
+func lrucontinue() error {
+	if !curlru.checkpoint() {
+		return errLRUAborted
 	}
 	return nil
 }
 
-func walkfunc(fqn string, osfi os.FileInfo, err error) error {
+// the traversal shared by the LRU and expiry (see expiry.go) jobs: skips system files
+// and directories, and the buckets not accepted by the filter; calls visit() for each object
+func walkobj(fqn string, osfi os.FileInfo, err error, cont func() error, filter func(bucket string) bool,
+	visit func(fqn string, stat *syscall.Stat_t) error) error {
 	if err != nil {
 		glog.Errorf("walkfunc callback invoked with err: %v", err)
 		return err
 	}
-	// skip system files and directories
-	if strings.HasPrefix(osfi.Name(), ".") || osfi.Mode().IsDir() {
+	// skip system files and directories (including erasure-coded slices, see ec.go)
+	if strings.HasPrefix(osfi.Name(), ".") {
+		if osfi.Mode().IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if err = cont(); err != nil {
+		return err
+	}
+	if osfi.Mode().IsDir() {
+		if _, ok := ctx.mountpaths[filepath.Dir(fqn)]; ok && !filter(osfi.Name()) {
+			return filepath.SkipDir
+		}
 		return nil
 	}
-	stat := osfi.Sys().(*syscall.Stat_t)
+	return visit(fqn, osfi.Sys().(*syscall.Stat_t))
+}
+
+func (c *lructx) lruvisit(fqn string, stat *syscall.Stat_t) error {
+	curlru.scanned(1)
 	atime := time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
 	mtime := time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec))
 	// atime controversy, see e.g. https://en.wikipedia.org/wiki/Stat_(system_call)#Criticism_of_atime
@@ -121,57 +186,80 @@ func walkfunc(fqn string, osfi os.FileInfo, err error) error {
 	if mtime.After(atime) {
 		usetime = mtime
 	}
-	now := time.Now()
-	dontevictime := now.Add(-ctx.config.Cache.DontEvictTime)
-	if usetime.After(dontevictime) {
+	objkey, err := filepath.Rel(c.mpath, fqn)
+	assert(err == nil && !strings.HasPrefix(objkey, "../"), fqn)
+	if c.skip[objkey] {
 		return nil
 	}
-	var (
-		h *maxheap
-		c *lructx
-	)
-	for mpath, hh := range maxheapmap {
-		rel, err := filepath.Rel(mpath, fqn)
-		if err == nil && !strings.HasPrefix(rel, "../") {
-			h = hh
-			c = lructxmap[mpath]
-			break
-		}
+	throttle(c.mpath)
+	// the walk also indexes the objects that were not tracked yet
+	if r := getatimerunner(); r != nil {
+		r.seed(fqn, usetime, stat.Size)
+	}
+	lrupush(c.h, c, &fileinfo{fqn: fqn, objkey: objkey, usetime: usetime, size: stat.Size})
+	return nil
+}
+
+func lrupush(h *maxheap, c *lructx, fi *fileinfo) {
+	dontevictime := time.Now().Add(-ctx.config.Cache.DontEvictTime)
+	if fi.usetime.After(dontevictime) {
+		return
 	}
-	assert(h != nil && c != nil)
+	split := strings.SplitN(fi.objkey, "/", 2)
+	if len(split) == 2 && pinned.ispinned(split[0], split[1]) {
+		return
+	}
+	fi.key, fi.overquota = evictor.key(fi), overquota(split[0])
 	// partial optimization:
 	// 	do nothing if the heap's cursize >= totsize &&
-	// 	the file is more recent then the the heap's newest
+	// 	the file is to be evicted after the heap's last
 	// full optimization (tbd) entails compacting the heap when its cursize >> totsize
-	if c.cursize >= c.totsize && usetime.After(c.newest) {
-		return nil
+	if c.cursize >= c.totsize && c.last != nil && evictsbefore(c.last, fi) {
+		return
 	}
 	// push and update the context
-	fi := &fileinfo{
-		fqn:     fqn,
-		usetime: usetime,
-		size:    stat.Size,
-	}
 	heap.Push(h, fi)
 	c.cursize += fi.size
-	if usetime.After(c.newest) {
-		c.newest = usetime
+	if c.last == nil || evictsbefore(c.last, fi) {
+		c.last = fi
 	}
-	return nil
 }
 
-func do_LRU(toevict int64, mpath string) error {
-	h := maxheapmap[mpath]
+// returns the remaining number of bytes to evict, zero if the lwm is reached
+func do_LRU(toevict int64, c *lructx) int64 {
+	h, mpath := c.h, c.mpath
+	r := getatimerunner()
+	lwm, _ := curlru.watermarks()
+	dryrun := curlru.dryrun()
 	var (
 		fevicted, bevicted int64
 		cnt                int
 	)
 	for h.Len() > 0 && toevict > 10 {
+		if !curlru.checkpoint() {
+			return 0
+		}
 		fi := heap.Pop(h).(*fileinfo)
+		if dryrun {
+			curlru.evicted(fi)
+			toevict -= fi.size
+			continue
+		}
+		throttle(mpath)
 		if err := os.Remove(fi.fqn); err != nil {
-			glog.Errorf("Failed to evict %q, err: %v", fi.fqn, err)
+			if os.IsNotExist(err) && r != nil {
+				r.remove(fi.fqn) // stale index entry
+			} else {
+				glog.Errorf("Failed to evict %q, err: %v", fi.fqn, err)
+			}
 			continue
 		}
+		if r != nil {
+			r.remove(fi.fqn)
+		}
+		evictor.evicted(fi)
+		curlru.evicted(fi)
+		bucketstats.add(bucketof(fi.objkey), "bytesevicted", fi.size)
 		toevict -= fi.size
 		bevicted += fi.size
 		fevicted++
@@ -181,27 +269,28 @@ func do_LRU(toevict int64, mpath string) error {
 			statfs := syscall.Statfs_t{}
 			if err := syscall.Statfs(mpath, &statfs); err == nil {
 				u := (statfs.Blocks - statfs.Bavail) * 100 / statfs.Blocks
-				if u <= uint64(ctx.config.Cache.FSLowWaterMark)+1 {
+				if u <= uint64(lwm)+1 {
 					break
 				}
 			}
 		}
 	}
-	if ctx.rg != nil { // FIXME: for *_test only
-		stats := getstorstats()
-		stats.add("bytesevicted", bevicted)
-		stats.add("filesevicted", fevicted)
-	}
+	stats := getstorstats()
+	stats.add("bytesevicted", bevicted)
+	stats.add("filesevicted", fevicted)
 	// final check
 	statfs := syscall.Statfs_t{}
-	if err := syscall.Statfs(mpath, &statfs); err == nil {
+	if err := syscall.Statfs(mpath, &statfs); err == nil && !dryrun {
 		u := (statfs.Blocks - statfs.Bavail) * 100 / statfs.Blocks
-		if u > uint64(ctx.config.Cache.FSLowWaterMark)+1 {
-			glog.Errorf("Failed to reach lwm %d for mpath %q: used %d%% rem-toevict %d",
-				ctx.config.Cache.FSLowWaterMark, mpath, u, toevict)
+		if u <= uint64(lwm)+1 {
+			return 0
 		}
+		glog.Infof("mpath %q: used %d%% lwm %d%% rem-toevict %d", mpath, u, lwm, toevict)
 	}
-	return nil
+	if toevict <= 10 {
+		return 0
+	}
+	return toevict
 }
 
 //===========================================================================
@@ -212,7 +301,7 @@ func do_LRU(toevict int64, mpath string) error {
 func (mh maxheap) Len() int { return len(mh) }
 
 func (mh maxheap) Less(i, j int) bool {
-	return mh[i].usetime.Before(mh[j].usetime)
+	return evictsbefore(mh[i], mh[j])
 }
 
 func (mh maxheap) Swap(i, j int) {
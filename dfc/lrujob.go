@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// LRU (eviction) job: started either automatically, upon reaching the high watermark
+// (see storstatsrunner.log), or via REST with optional watermarks; can be paused,
+// resumed and aborted; in the dry-run mode reports what would be evicted
+// without removing anything. Runs as the XactLRU xaction, see xaction.go.
+
+const lrudryrunmax = 1000 // max number of objects reported by a dry run
+
+// PUT '{"action": "lru", "param1": "start", "value": {LRUMsg}}' /v1/daemon (or /v1/cluster)
+type LRUMsg struct {
+	Lwm    uint32 `json:"lwm,omitempty"` // 0: use the configured FSLowWaterMark
+	Hwm    uint32 `json:"hwm,omitempty"` // 0: use the configured FSHighWaterMark
+	DryRun bool   `json:"dryrun,omitempty"`
+}
+
+// ActionLRU Param1 enum
+const (
+	LRUStart  = "start"
+	LRUPause  = "pause"
+	LRUResume = "resume"
+	LRUAbort  = "abort"
+)
+
+// GET '{"what": "lru"}' /v1/daemon
+type LRUStatus struct {
+	Running      bool          `json:"running"`
+	Paused       bool          `json:"paused"`
+	Aborted      bool          `json:"aborted"`
+	DryRun       bool          `json:"dryrun"`
+	Lwm          uint32        `json:"lwm"`
+	Hwm          uint32        `json:"hwm"`
+	Started      time.Time     `json:"started"`
+	Finished     time.Time     `json:"finished"`
+	Mpaths       []string      `json:"mpaths"` // in progress
+	Filesscanned int64         `json:"filesscanned"`
+	Filesevicted int64         `json:"filesevicted"` // dry run: would be evicted
+	Bytesevicted int64         `json:"bytesevicted"`
+	Bytestoevict int64         `json:"bytestoevict"`
+	ETA          time.Duration `json:"eta"`
+	Dryrunlist   []string      `json:"dryrunlist,omitempty"` // up to lrudryrunmax objects
+}
+
+type lrujob struct {
+	sync.Mutex
+	status LRUStatus
+	mpaths map[string]bool
+	resume chan struct{} // closed upon resume or abort
+	xact   *xaction
+}
+
+var (
+	curlru         = &lrujob{}
+	errLRURunning  = errors.New("LRU is already running")
+	errLRUIdle     = errors.New("LRU is not running")
+	errLRUAborted  = errors.New("LRU aborted")
+	errLRUNotPause = errors.New("LRU is not paused")
+)
+
+func startlru(msg *LRUMsg) error {
+	j := curlru
+	j.Lock()
+	defer j.Unlock()
+	if j.status.Running {
+		return errLRURunning
+	}
+	x, err := xactreg.start(XactLRU)
+	if err != nil {
+		return err
+	}
+	x.detailfn = func() interface{} { return j.getstatus() }
+	j.xact = x
+	j.status = LRUStatus{
+		Running: true,
+		DryRun:  msg.DryRun,
+		Lwm:     msg.Lwm,
+		Hwm:     msg.Hwm,
+		Started: time.Now(),
+	}
+	if j.status.Lwm == 0 {
+		j.status.Lwm = ctx.config.Cache.FSLowWaterMark
+	}
+	if j.status.Hwm == 0 {
+		j.status.Hwm = ctx.config.Cache.FSHighWaterMark
+	}
+	j.mpaths = make(map[string]bool)
+	j.resume = nil
+	go all_LRU()
+	return nil
+}
+
+func (j *lrujob) control(action string) error {
+	j.Lock()
+	defer j.Unlock()
+	if !j.status.Running {
+		return errLRUIdle
+	}
+	switch action {
+	case LRUPause:
+		if !j.status.Paused {
+			j.status.Paused, j.resume = true, make(chan struct{})
+		}
+	case LRUResume:
+		if !j.status.Paused {
+			return errLRUNotPause
+		}
+		j.status.Paused = false
+		close(j.resume)
+	case LRUAbort:
+		if j.status.Paused {
+			j.status.Paused = false
+			close(j.resume)
+		}
+		if !j.status.Aborted {
+			j.status.Aborted = true
+			j.xact.abort()
+		}
+	}
+	glog.Infof("LRU %s", action)
+	return nil
+}
+
+func (j *lrujob) finish() {
+	j.Lock()
+	j.status.Running, j.status.Paused, j.status.Finished = false, false, time.Now()
+	j.status.Mpaths = nil
+	x := j.xact
+	j.Unlock()
+	if x != nil {
+		xactreg.finish(x)
+	}
+}
+
+// blocks while paused; returns false if aborted (see also xactions.abort)
+func (j *lrujob) checkpoint() bool {
+	j.Lock()
+	paused, resume := j.status.Paused, j.resume
+	var done <-chan struct{}
+	if j.status.Running && j.xact != nil {
+		done = j.xact.ctx.Done()
+	}
+	j.Unlock()
+	if paused {
+		select {
+		case <-resume:
+		case <-done:
+		}
+	}
+	select {
+	case <-done:
+		j.Lock()
+		j.status.Aborted = true
+		j.Unlock()
+		return false
+	default:
+		return true
+	}
+}
+
+// watermarks of the current job or, when not running (e.g., *_test), the configured ones
+func (j *lrujob) watermarks() (lwm, hwm uint32) {
+	j.Lock()
+	defer j.Unlock()
+	if j.status.Running {
+		return j.status.Lwm, j.status.Hwm
+	}
+	return ctx.config.Cache.FSLowWaterMark, ctx.config.Cache.FSHighWaterMark
+}
+
+func (j *lrujob) dryrun() bool {
+	j.Lock()
+	defer j.Unlock()
+	return j.status.Running && j.status.DryRun
+}
+
+func (j *lrujob) begin(mpath string, toevict int64) {
+	j.Lock()
+	if j.mpaths != nil {
+		j.mpaths[mpath] = true
+	}
+	j.status.Bytestoevict += toevict
+	j.Unlock()
+}
+
+func (j *lrujob) end(mpath string) {
+	j.Lock()
+	delete(j.mpaths, mpath)
+	j.Unlock()
+}
+
+func (j *lrujob) scanned(n int64) {
+	j.Lock()
+	j.status.Filesscanned += n
+	j.Unlock()
+}
+
+func (j *lrujob) evicted(fi *fileinfo) {
+	j.Lock()
+	j.status.Filesevicted++
+	j.status.Bytesevicted += fi.size
+	if j.status.DryRun && len(j.status.Dryrunlist) < lrudryrunmax {
+		j.status.Dryrunlist = append(j.status.Dryrunlist, fi.fqn)
+	}
+	j.Unlock()
+}
+
+func (j *lrujob) getstatus() *LRUStatus {
+	j.Lock()
+	defer j.Unlock()
+	status := j.status
+	status.Dryrunlist = append([]string(nil), j.status.Dryrunlist...)
+	for mpath := range j.mpaths {
+		status.Mpaths = append(status.Mpaths, mpath)
+	}
+	sort.Strings(status.Mpaths)
+	// ETA: assuming the eviction rate so far
+	if status.Running && status.Bytesevicted > 0 && status.Bytestoevict > status.Bytesevicted {
+		elapsed := time.Since(status.Started)
+		status.ETA = time.Duration(float64(elapsed) *
+			float64(status.Bytestoevict-status.Bytesevicted) / float64(status.Bytesevicted))
+	}
+	return &status
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// LRU job: pause, resume and abort; the dry run reports both the indexed and the walked objects;
+// the concurrent per-mountpath runs.
+//
+// Example run:
+// 	go test -v -run=lrujob
+//
+package dfc
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_lrujobcontrol(t *testing.T) {
+	x, err := xactreg.start(XactLRU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &lrujob{status: LRUStatus{Running: true}, mpaths: make(map[string]bool), xact: x}
+	checkpoint := func() chan bool {
+		ch := make(chan bool, 1)
+		go func() { ch <- j.checkpoint() }()
+		return ch
+	}
+	expect := func(ch chan bool, cont bool) {
+		select {
+		case ok := <-ch:
+			if ok != cont {
+				t.Errorf("Expected checkpoint %v, got %v", cont, ok)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Checkpoint is blocked")
+		}
+	}
+	expect(checkpoint(), true)
+	if err := j.control(LRUResume); err != errLRUNotPause {
+		t.Errorf("Expected %v, got %v", errLRUNotPause, err)
+	}
+	// pause blocks the checkpoint until resumed
+	if err := j.control(LRUPause); err != nil || !j.getstatus().Paused {
+		t.Fatalf("Failed to pause, err: %v", err)
+	}
+	ch := checkpoint()
+	select {
+	case <-ch:
+		t.Fatalf("Expected the checkpoint to block while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := j.control(LRUResume); err != nil || j.getstatus().Paused {
+		t.Fatalf("Failed to resume, err: %v", err)
+	}
+	expect(ch, true)
+	// abort while paused unblocks and stops
+	if err := j.control(LRUPause); err != nil {
+		t.Fatal(err)
+	}
+	ch = checkpoint()
+	if err := j.control(LRUAbort); err != nil {
+		t.Fatal(err)
+	}
+	expect(ch, false)
+	if status := j.getstatus(); !status.Aborted || status.Paused {
+		t.Errorf("Unexpected status after abort %+v", status)
+	}
+	expect(checkpoint(), false)
+	j.finish()
+	if status := j.getstatus(); status.Running || status.Finished.IsZero() {
+		t.Errorf("Unexpected status after finish %+v", status)
+	}
+	if err := j.control(LRUPause); err != errLRUIdle {
+		t.Errorf("Expected %v, got %v", errLRUIdle, err)
+	}
+}
+
+func Test_lrujobdryrun(t *testing.T) {
+	mpath, err := ioutil.TempDir("", "lrudryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mpath)
+	savedmp, savedbmd, savedrg, savedlru := ctx.mountpaths, ctx.bmd, ctx.rg, curlru
+	defer func() { ctx.mountpaths, ctx.bmd, ctx.rg, curlru = savedmp, savedbmd, savedrg, savedlru }()
+	ctx.mountpaths = map[string]*mountPath{mpath: {Path: mpath}}
+	ctx.bmd = newbucketmd()
+	r := &atimerunner{}
+	ctx.rg = &rungroup{runmap: map[string]runner{xatime: r}}
+	// evict everything: zero watermarks
+	curlru = &lrujob{status: LRUStatus{Running: true, DryRun: true}, mpaths: make(map[string]bool)}
+
+	// "indexed" is tracked by the access index, "walked" is not
+	for _, name := range []string{"indexed", "walked"} {
+		quotaobj(t, mpath+"/bucket/"+name, 100, time.Hour)
+	}
+	r.seed(mpath+"/bucket/indexed", time.Now().Add(-time.Hour), 100)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	if err := one_LRU(mpath, wg); err != nil {
+		t.Fatal(err)
+	}
+	status := curlru.getstatus()
+	sort.Strings(status.Dryrunlist)
+	if len(status.Dryrunlist) != 2 || status.Dryrunlist[0] != mpath+"/bucket/indexed" ||
+		status.Dryrunlist[1] != mpath+"/bucket/walked" {
+		t.Errorf("Expected both objects reported once, got %v", status.Dryrunlist)
+	}
+	for _, name := range []string{"indexed", "walked"} {
+		if _, err := os.Stat(mpath + "/bucket/" + name); err != nil {
+			t.Errorf("A dry run must not evict, err: %v", err)
+		}
+	}
+}
+
+func Test_lrujobmpaths(t *testing.T) {
+	savedmp, savedbmd, savedrg, savedlru := ctx.mountpaths, ctx.bmd, ctx.rg, curlru
+	defer func() { ctx.mountpaths, ctx.bmd, ctx.rg, curlru = savedmp, savedbmd, savedrg, savedlru }()
+	dir := testmountpaths(t, 4) // see mirror_test.go
+	defer os.RemoveAll(dir)
+	ctx.bmd = newbucketmd()
+	ctx.rg = &rungroup{runmap: map[string]runner{xatime: &atimerunner{}}}
+	curlru = &lrujob{status: LRUStatus{Running: true, DryRun: true}, mpaths: make(map[string]bool)}
+
+	expected := make([]string, 0, len(ctx.mountpaths))
+	for mpath := range ctx.mountpaths {
+		quotaobj(t, mpath+"/bucket/obj", 100, time.Hour)
+		expected = append(expected, mpath+"/bucket/obj")
+	}
+	// walked concurrently, each by its own LRU context
+	wg := &sync.WaitGroup{}
+	for mpath := range ctx.mountpaths {
+		wg.Add(1)
+		go one_LRU(mpath, wg)
+	}
+	wg.Wait()
+	status := curlru.getstatus()
+	sort.Strings(status.Dryrunlist)
+	sort.Strings(expected)
+	if len(status.Dryrunlist) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, status.Dryrunlist)
+	}
+	for i := range expected {
+		if status.Dryrunlist[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, status.Dryrunlist)
+			break
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Prometheus metrics: GET /metrics on the proxy and the targets returns the stats registry
+// (see stats.go), the mountpath usage and the bucket quotas in the Prometheus text
+// exposition format (https://prometheus.io/docs/instrumenting/exposition_formats),
+// each labeled with the daemon ID and role.
+
+const metricsprefix = "dfc_"
+
+//===========================
+//
+// /metrics handler
+//
+//===========================
+func (r *httprunner) metricshdlr(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		invalhdlr(w, req)
+		return
+	}
+	role := xproxy
+	if _, ok := ctx.rg.runmap[xstorstats]; ok {
+		role = xtarget
+	}
+	m := &metricswriter{labels: fmt.Sprintf("daemon=%s,role=%s", strconv.Quote(r.si.DaemonID), strconv.Quote(role))}
+	if role == xproxy {
+		m.registry(proxyreg)
+	} else {
+		rr := getstorstatsrunner()
+		m.registry(storreg)
+		if hitratio, ok := rr.getstats()["hitratio"].(float64); ok {
+			m.gauge("hitratio_percent", "", hitratio)
+		}
+		for bucket, q := range quotausage() {
+			l := "bucket=" + strconv.Quote(bucket)
+			m.gauge("quota_bytes", l, float64(q.Quota))
+			m.gauge("quota_used_bytes", l, float64(q.Used))
+		}
+		for mpath, used := range rr.getused() {
+			m.gauge("mountpath_used_percent", "mountpath="+strconv.Quote(mpath), float64(used))
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(m.buf.Bytes())
+}
+
+type metricswriter struct {
+	buf    bytes.Buffer
+	labels string // common
+	typed  map[string]bool
+}
+
+func (m *metricswriter) line(name, typ, labels string, val string) {
+	m.header(name, typ)
+	fmt.Fprintf(&m.buf, "%s{%s} %s\n", name, m.all(labels), val)
+}
+
+// TYPE comment, once per metric
+func (m *metricswriter) header(name, typ string) {
+	if m.typed == nil {
+		m.typed = make(map[string]bool)
+	}
+	if !m.typed[name] {
+		m.typed[name] = true
+		fmt.Fprintf(&m.buf, "# TYPE %s %s\n", name, typ)
+	}
+}
+
+func (m *metricswriter) all(labels string) string {
+	if labels == "" {
+		return m.labels
+	}
+	return m.labels + "," + labels
+}
+
+func (m *metricswriter) registry(reg *statsregistry) {
+	for _, name := range reg.names {
+		mt := reg.metrics[name]
+		switch mt.kind {
+		case statsCounter:
+			m.line(metricsprefix+mt.promname, "counter", mt.labels, strconv.FormatInt(atomic.LoadInt64(&mt.val), 10))
+		case statsGauge:
+			m.line(metricsprefix+mt.promname, "gauge", mt.labels, strconv.FormatInt(atomic.LoadInt64(&mt.val), 10))
+		case statsLatency:
+			m.histogram(mt.promname, mt.labels, mt.hist)
+		}
+	}
+}
+
+func (m *metricswriter) gauge(name, labels string, val float64) {
+	m.line(metricsprefix+name, "gauge", labels, strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+func (m *metricswriter) histogram(name, labels string, h *histogram) {
+	name = metricsprefix + name
+	m.header(name, "histogram")
+	l := m.all(labels)
+	var cumulative int64
+	for i := range h.counts {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		le := "+Inf"
+		if i < len(latencybuckets) {
+			le = strconv.FormatFloat(latencybuckets[i].Seconds(), 'g', -1, 64)
+		}
+		fmt.Fprintf(&m.buf, "%s_bucket{%s,le=%q} %d\n", name, l, le, cumulative)
+	}
+	sum := time.Duration(atomic.LoadInt64(&h.sum))
+	fmt.Fprintf(&m.buf, "%s_sum{%s} %s\n", name, l, strconv.FormatFloat(sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(&m.buf, "%s_count{%s} %d\n", name, l, atomic.LoadInt64(&h.count))
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Prometheus metrics: the stats registry's counters, gauges and latency histograms in the text format.
+//
+// Example run:
+// 	go test -v -run=metrics
+//
+package dfc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_metrics(t *testing.T) {
+	reg := newstatsregistry()
+	reg.counter("numget")
+	reg.gauge("bytespinned")
+	reg.register("aws.numcoldget", statsCounter, "cloud_numcoldget", `provider="aws"`)
+	reg.latency(OpGetHit)
+	reg.add("numget", 7)
+	reg.set("bytespinned", 10)
+	reg.add("aws.numcoldget", 3)
+	reg.observe(OpGetHit, 500*time.Microsecond)
+	reg.observe(OpGetHit, 3*time.Millisecond)
+	reg.observe(OpGetHit, time.Minute)
+
+	m := &metricswriter{labels: `daemon="1:8081",role="target"`}
+	m.registry(reg)
+	out := m.buf.String()
+
+	for _, expected := range []string{
+		"# TYPE dfc_numget counter\n",
+		`dfc_numget{daemon="1:8081",role="target"} 7` + "\n",
+		"# TYPE dfc_bytespinned gauge\n",
+		`dfc_cloud_numcoldget{daemon="1:8081",role="target",provider="aws"} 3` + "\n",
+		"# TYPE dfc_latency_seconds histogram\n",
+		`dfc_latency_seconds_bucket{daemon="1:8081",role="target",op="gethit",le="0.001"} 1` + "\n",
+		`dfc_latency_seconds_bucket{daemon="1:8081",role="target",op="gethit",le="0.005"} 2` + "\n",
+		`dfc_latency_seconds_bucket{daemon="1:8081",role="target",op="gethit",le="+Inf"} 3` + "\n",
+		`dfc_latency_seconds_count{daemon="1:8081",role="target",op="gethit"} 3` + "\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out)
+		}
+	}
+	if strings.Count(out, "# TYPE dfc_numget ") != 1 {
+		t.Errorf("Unexpected metrics:\n%s", out)
+	}
+}
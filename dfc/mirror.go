@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Local mirroring: an object of a mirrored bucket is stored on its HRW mountpath
+// (see hrwMpath) and, additionally, on the next-best mountpaths in the HRW order.
+// Reads are served from the least loaded copy; missing copies get restored
+// in the background when a mountpath is disabled.
+
+// number of local copies for a given bucket (1: not mirrored)
+func mirrorcopies(bucket string) int {
+	_, bucket = parsebucket(bucket) // see cachebucket
+	n := ctx.config.Mirror.Copies
+	if props := ctx.bmd.getprops(bucket); props.Mirror > 0 {
+		n = props.Mirror
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// fully qualified names of the object's local copies, the primary copy first
+func mirrorfqns(bucket, objname string) []string {
+	mpaths := hrwMpaths(bucket+"/"+objname, mirrorcopies(bucket))
+	fqns := make([]string, len(mpaths))
+	for i, mpath := range mpaths {
+		fqns[i] = mpath + "/" + bucket + "/" + objname
+	}
+	return fqns
+}
+
+func fqn2mountpath(fqn string) *mountPath {
+	for _, mountpath := range ctx.mountpaths {
+		rel, err := filepath.Rel(mountpath.Path, fqn)
+		if err == nil && !strings.HasPrefix(rel, "../") {
+			return mountpath
+		}
+	}
+	return nil
+}
+
+// locates the least loaded existing copy of the object; returns empty fqn if not cached
+func mirrorlookup(bucket, objname string) (fqn string, mountpath *mountPath) {
+	for _, f := range mirrorfqns(bucket, objname) {
+		if _, err := os.Stat(f); err != nil {
+			continue
+		}
+		mp := fqn2mountpath(f)
+		if mp == nil {
+			continue
+		}
+		if mountpath == nil || atomic.LoadInt64(&mp.inflight) < atomic.LoadInt64(&mountpath.inflight) {
+			fqn, mountpath = f, mp
+		}
+	}
+	return
+}
+
+var mirrorwg sync.WaitGroup // background copying, see gomirrorobj
+
+// mirrorobj in the background
+func gomirrorobj(bucket, objname, srcfqn string) {
+	mirrorwg.Add(1)
+	go func() {
+		defer mirrorwg.Done()
+		mirrorobj(bucket, objname, srcfqn)
+	}()
+}
+
+// creates the missing local copies of a given (cached) object
+func mirrorobj(bucket, objname, srcfqn string) (copied int) {
+	for _, fqn := range mirrorfqns(bucket, objname) {
+		if fqn == srcfqn {
+			continue
+		}
+		if _, err := os.Stat(fqn); err == nil {
+			continue
+		}
+		if err := copyfile(srcfqn, fqn); err != nil {
+			glog.Errorf("Failed to mirror %q => %q, err: %v", srcfqn, fqn, err)
+			checksetmounterror(fqn)
+			continue
+		}
+		copied++
+		if !ctx.bmd.islocal(bucket) {
+			if fi, err := os.Stat(fqn); err == nil {
+				atimetouch(fqn, fi.Size())
+			}
+		}
+		if glog.V(3) {
+			glog.Infof("Mirrored %q => %q", srcfqn, fqn)
+		}
+	}
+	return
+}
+
+// copies via a temporary file, so that a partial copy is never visible under the final name
+func copyfile(srcfqn, dstfqn string) error {
+	src, err := os.Open(srcfqn)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	finfo, err := src.Stat() // before reading: preserve the times, see below
+	if err != nil {
+		return err
+	}
+	if err = CreateDir(filepath.Dir(dstfqn)); err != nil {
+		return err
+	}
+	tmpfqn := filepath.Dir(dstfqn) + "/." + filepath.Base(dstfqn) + ".tmp"
+	dst, err := os.Create(tmpfqn)
+	if err != nil {
+		return err
+	}
+	if _, err = copyBuffer(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmpfqn)
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(tmpfqn)
+		return err
+	}
+	// carry over the checksum, if any
+	buf := make([]byte, 32)
+	if n, err := syscall.Getxattr(srcfqn, xattrXXHash, buf); err == nil {
+		syscall.Setxattr(tmpfqn, xattrXXHash, buf[:n], 0)
+	}
+	// and the access and modification times: the copy must not look recently used (see lru.go)
+	stat := finfo.Sys().(*syscall.Stat_t)
+	atime := time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+	if err = os.Chtimes(tmpfqn, atime, finfo.ModTime()); err != nil {
+		glog.Errorf("Failed to preserve the times of %q, err: %v", srcfqn, err)
+	}
+	return os.Rename(tmpfqn, dstfqn)
+}
+
+//===========================
+//
+// restoring copies upon mountpath failure
+//
+//===========================
+func restoremirrors() {
+	x, err := xactreg.start(XactMirror)
+	if err != nil {
+		glog.Infoln(err)
+		return
+	}
+	defer xactreg.finish(x)
+	wg := &sync.WaitGroup{}
+	fsmap := make(map[syscall.Fsid]bool, len(ctx.mountpaths))
+	glog.Infoln("restoremirrors start")
+	for _, mountpath := range ctx.mountpaths {
+		if !mountpath.isenabled() {
+			continue
+		}
+		if _, ok := fsmap[mountpath.Fsid]; ok {
+			continue
+		}
+		fsmap[mountpath.Fsid] = true
+		wg.Add(1)
+		go oneRestoreMirrors(mountpath.Path, wg, x)
+	}
+	wg.Wait()
+	glog.Infoln("restoremirrors done")
+}
+
+func oneRestoreMirrors(mpath string, wg *sync.WaitGroup, x *xaction) {
+	defer wg.Done()
+	var copied int
+	walk := func(fqn string, osfi os.FileInfo, err error) error {
+		if err != nil {
+			glog.Errorf("restoremirrors walk callback invoked with err: %v", err)
+			return err
+		}
+		if x.aborted() {
+			return errXactAborted
+		}
+		if strings.HasPrefix(osfi.Name(), ".") {
+			if osfi.Mode().IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(mpath, fqn)
+		if err != nil || rel == "." {
+			return nil
+		}
+		split := strings.SplitN(rel, "/", 2)
+		if osfi.Mode().IsDir() {
+			if len(split) == 1 && mirrorcopies(split[0]) < 2 {
+				return filepath.SkipDir // not mirrored
+			}
+			return nil
+		}
+		if len(split) < 2 {
+			return nil
+		}
+		throttle(mpath)
+		n := mirrorobj(split[0], split[1], fqn)
+		copied += n
+		x.add("filesscanned", 1)
+		x.add("filesrestored", int64(n))
+		return nil
+	}
+	if err := filepath.Walk(mpath, walk); err != nil {
+		glog.Errorf("Failed to traverse mpath %q, err: %v", mpath, err)
+	}
+	glog.Infof("restoremirrors: mpath %q, restored %d copies", mpath, copied)
+}
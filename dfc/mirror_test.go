@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Local mirroring: HRW order of the mountpaths, disabled mountpaths, lookup of the least loaded copy,
+// validation of the copies, copying with the original times.
+//
+// Example run:
+// 	go test -v -run=mirror
+//
+package dfc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testmountpaths(t *testing.T, n int) (dir string) {
+	dir, err := ioutil.TempDir("", "mpaths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.mountpaths = make(map[string]*mountPath, n)
+	for i := 0; i < n; i++ {
+		mpath := filepath.Join(dir, "mp"+string(rune('0'+i)))
+		if err = os.MkdirAll(mpath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		ctx.mountpaths[mpath] = &mountPath{Path: mpath}
+	}
+	return
+}
+
+func Test_mirrorhrw(t *testing.T) {
+	savedmp := ctx.mountpaths
+	defer func() { ctx.mountpaths = savedmp }()
+	dir := testmountpaths(t, 4)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"bucket/a", "bucket/b", "other/dir/c"} {
+		all := hrwMpaths(name, 10)
+		if len(all) != 4 || all[0] != hrwMpath(name) {
+			t.Fatalf("%s: unexpected %v (hrwMpath %s)", name, all, hrwMpath(name))
+		}
+		if two := hrwMpaths(name, 2); len(two) != 2 || two[0] != all[0] || two[1] != all[1] {
+			t.Errorf("%s: expected a prefix of %v, got %v", name, all, two)
+		}
+		// disabling the best mountpath promotes the next one
+		ctx.mountpaths[all[0]].setenabled(false)
+		if mpath := hrwMpath(name); mpath != all[1] {
+			t.Errorf("%s: expected %s, got %s", name, all[1], mpath)
+		}
+		if three := hrwMpaths(name, 10); len(three) != 3 || three[0] != all[1] {
+			t.Errorf("%s: unexpected %v", name, three)
+		}
+		ctx.mountpaths[all[0]].setenabled(true)
+	}
+}
+
+func Test_mirrorlookup(t *testing.T) {
+	savedmp, savedbmd, savedcopies := ctx.mountpaths, ctx.bmd, ctx.config.Mirror.Copies
+	defer func() { ctx.mountpaths, ctx.bmd, ctx.config.Mirror.Copies = savedmp, savedbmd, savedcopies }()
+	dir := testmountpaths(t, 3)
+	defer os.RemoveAll(dir)
+	ctx.bmd = newbucketmd()
+	ctx.config.Mirror.Copies = 2
+
+	fqns := mirrorfqns("bucket", "obj")
+	if len(fqns) != 2 {
+		t.Fatalf("Expected 2 copies, got %v", fqns)
+	}
+	if fqn, _ := mirrorlookup("bucket", "obj"); fqn != "" {
+		t.Errorf("Expected no copies, got %q", fqn)
+	}
+	for _, fqn := range fqns {
+		if err := os.MkdirAll(filepath.Dir(fqn), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the mirror only
+	if err := ioutil.WriteFile(fqns[1], []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fqn, mp := mirrorlookup("bucket", "obj"); fqn != fqns[1] || mp != fqn2mountpath(fqns[1]) {
+		t.Errorf("Expected %q, got %q", fqns[1], fqn)
+	}
+	// both: the least loaded one
+	if err := ioutil.WriteFile(fqns[0], []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fqn2mountpath(fqns[0]).inflight = 5
+	if fqn, _ := mirrorlookup("bucket", "obj"); fqn != fqns[1] {
+		t.Errorf("Expected the least loaded %q, got %q", fqns[1], fqn)
+	}
+	fqn2mountpath(fqns[0]).inflight = 0
+	fqn2mountpath(fqns[1]).inflight = 5
+	if fqn, _ := mirrorlookup("bucket", "obj"); fqn != fqns[0] {
+		t.Errorf("Expected the least loaded %q, got %q", fqns[0], fqn)
+	}
+}
+
+func Test_mirrorvalidcached(t *testing.T) {
+	savedmp, savedbmd, savedcopies := ctx.mountpaths, ctx.bmd, ctx.config.Mirror.Copies
+	defer func() { ctx.mountpaths, ctx.bmd, ctx.config.Mirror.Copies = savedmp, savedbmd, savedcopies }()
+	dir := testmountpaths(t, 3)
+	defer os.RemoveAll(dir)
+	ctx.bmd = newbucketmd()
+	ctx.bmd.add("lb")
+	ctx.config.Mirror.Copies = 2
+
+	fqns := mirrorfqns("lb", "obj")
+	for _, fqn := range fqns {
+		if err := os.MkdirAll(filepath.Dir(fqn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fqn, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := setxxhash(fqn); err != nil {
+			t.Skipf("xattrs are not supported: %v", err)
+		}
+	}
+	// corrupt the primary copy
+	if err := ioutil.WriteFile(fqns[0], []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := &targetrunner{}
+	tr.statsif = newstatsregistry()
+	props := BucketProps{Checksum: ChecksumXXHash}
+	if fqn := tr.validcached("lb", "obj", fqns[0], &props, true); fqn != fqns[1] {
+		t.Fatalf("Expected the good mirror %q, got %q", fqns[1], fqn)
+	}
+	if _, err := os.Stat(fqns[1]); err != nil {
+		t.Errorf("The good mirror must be kept, err: %v", err)
+	}
+	// the primary gets restored in the background
+	mirrorwg.Wait()
+	if b, err := ioutil.ReadFile(fqns[0]); err != nil || string(b) != "data" {
+		t.Errorf("The corrupted copy %q was not restored, err: %v", fqns[0], err)
+	}
+}
+
+func Test_mirrorcopyfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copyfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := dir+"/src", dir+"/mp1/bucket/dst"
+	if err = ioutil.WriteFile(src, []byte("object"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	atime, mtime := time.Now().Add(-time.Hour).Truncate(time.Second), time.Now().Add(-2*time.Hour).Truncate(time.Second)
+	if err = os.Chtimes(src, atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err = copyfile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	finfo, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !finfo.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, finfo.ModTime())
+	}
+	stat := finfo.Sys().(*syscall.Stat_t)
+	if got := time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec)); !got.Equal(atime) {
+		t.Errorf("Expected atime %v, got %v", atime, got)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/golang/glog"
@@ -16,13 +17,27 @@ import (
 
 // FIXME: mountPath encapsulates mount specific information on a local node (remove)
 type mountPath struct {
-	Device  string
-	Path    string
-	Type    string
-	Opts    []string
-	Fsid    syscall.Fsid
-	errcnt  int
-	enabled bool
+	Device   string
+	Path     string
+	Type     string
+	Opts     []string
+	Fsid     syscall.Fsid
+	errcnt   int64 // atomic
+	disabled int32 // atomic: written on errors, read by the GET handlers, see hrw.go
+	inflight int64 // num reads in progress (see mirror.go)
+}
+
+func (mp *mountPath) isenabled() bool {
+	return atomic.LoadInt32(&mp.disabled) == 0
+}
+
+// returns true if the mountpath was enabled prior to the call
+func (mp *mountPath) setenabled(enabled bool) bool {
+	var disabled int32
+	if !enabled {
+		disabled = 1
+	}
+	return atomic.SwapInt32(&mp.disabled, disabled) == 0
 }
 
 const (
@@ -54,12 +69,11 @@ func parseProcMounts(filename string) error {
 			if glog.V(3) {
 				glog.Infof("Found mp %s", fields[1])
 			}
-			mp := mountPath{
-				Device:  fields[0],
-				Path:    fields[1],
-				Type:    fields[2],
-				Opts:    strings.Split(fields[3], ","),
-				enabled: true,
+			mp := &mountPath{
+				Device: fields[0],
+				Path:   fields[1],
+				Type:   fields[2],
+				Opts:   strings.Split(fields[3], ","),
 			}
 			statfs := syscall.Statfs_t{}
 			if err := syscall.Statfs(mp.Path, &statfs); err != nil {
@@ -79,10 +93,7 @@ func parseProcMounts(filename string) error {
 func emulateCachepathMounts() {
 	for i := 0; i < ctx.config.Cache.CachePathCount; i++ {
 		mpath := ctx.config.Cache.CachePath + dfcStoreMntPrefix + strconv.Itoa(i)
-		mp := mountPath{
-			Path:    mpath,
-			enabled: true,
-		}
+		mp := &mountPath{Path: mpath}
 		statfs := syscall.Statfs_t{}
 		if err := syscall.Statfs(mp.Path, &statfs); err != nil {
 			glog.Fatalf("Failed to statfs mp %q, err: %v", mp.Path, err)
@@ -114,7 +125,11 @@ func checkdfcsignature(path string) bool {
 func setMountPathStatus(path string, status bool) {
 	for _, mountpath := range ctx.mountpaths {
 		if strings.HasPrefix(path, mountpath.Path) {
-			mountpath.enabled = status
+			wasenabled := mountpath.setenabled(status)
+			if wasenabled && !status {
+				glog.Errorf("Disabled mp %q (error count %d)", mountpath.Path, atomic.LoadInt64(&mountpath.errcnt))
+				go restoremirrors()
+			}
 			return
 		}
 	}
@@ -124,7 +139,7 @@ func setMountPathStatus(path string, status bool) {
 func getMountPathErrorCount(path string) int {
 	for _, mountpath := range ctx.mountpaths {
 		if strings.HasPrefix(path, mountpath.Path) {
-			return mountpath.errcnt
+			return int(atomic.LoadInt64(&mountpath.errcnt))
 		}
 	}
 	return 0
@@ -134,7 +149,7 @@ func getMountPathErrorCount(path string) int {
 func incrMountPathErrorCount(path string) {
 	for _, mountpath := range ctx.mountpaths {
 		if strings.HasPrefix(path, mountpath.Path) {
-			mountpath.errcnt++
+			atomic.AddInt64(&mountpath.errcnt, 1)
 			return
 		}
 	}
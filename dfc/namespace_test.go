@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Provider namespaces: same-named buckets of different cloud providers must not collide
+// in the cache, and the proxy must route the namespaced names the way the targets place them
+// and store the bucket properties under the bucket name.
+//
+// Example run:
+// 	go test -v -run=namespace
+//
+package dfc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_namespace(t *testing.T) {
+	savedmp, savedbmd, savedsmap, savedconfig := ctx.mountpaths, ctx.bmd, ctx.smap, ctx.config
+	defer func() { ctx.mountpaths, ctx.bmd, ctx.smap, ctx.config = savedmp, savedbmd, savedsmap, savedconfig }()
+	dir := testmountpaths(t, 4)
+	defer os.RemoveAll(dir)
+	ctx.config.CloudProvider, ctx.config.CloudProviders = amazoncloud, []string{amazoncloud, googlecloud}
+	ctx.config.Proxy.Passthru = true
+	ctx.bmd = newbucketmd()
+	ctx.bmd.add("lb")
+	ctx.bmd.setprops("gbucket", &BucketProps{CloudProvider: googlecloud})
+
+	tests := []struct {
+		nsbucket, cbucket string
+	}{
+		{"foo", "foo"},
+		{"s3:foo", "foo"}, // the default provider
+		{"gs:foo", "gs:foo"},
+		{"gbucket", "gs:gbucket"},
+		{"gs:gbucket", "gs:gbucket"},
+		{"s3:gbucket", "gbucket"},
+		{"lb", "lb"},
+		{"s3:lb", "lb"}, // local buckets are not qualified
+	}
+	for _, test := range tests {
+		if cbucket := cachebucket(parsebucket(test.nsbucket)); cbucket != test.cbucket {
+			t.Errorf("%s: expected %q, got %q", test.nsbucket, test.cbucket, cbucket)
+		}
+	}
+	tr := &targetrunner{}
+	if tr.fqn(cachebucket(parsebucket("s3:foo")), "x") == tr.fqn(cachebucket(parsebucket("gs:foo")), "x") {
+		t.Errorf("s3:foo/x and gs:foo/x collide at %q", tr.fqn("foo", "x"))
+	}
+	if fqn := tr.fqn(cachebucket(parsebucket("gs:foo")), "x"); !strings.HasSuffix(fqn, "/gs:foo/x") {
+		t.Errorf("Unexpected fqn %q", fqn)
+	}
+
+	// proxy: redirects to the targets that place the objects
+	ctx.smap = &Smap{Smap: make(map[string]*ServerInfo)}
+	for _, sid := range []string{"t1", "t2", "t3", "t4", "t5"} {
+		ctx.smap.Smap[sid] = &ServerInfo{DaemonID: sid, DirectURL: "http://" + sid}
+	}
+	p := &proxyrunner{}
+	p.statsif = newstatsregistry()
+	redirect := func(method, path string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		if method == http.MethodGet {
+			p.httpfilget(w, r)
+		} else {
+			p.redirectlocal(w, r)
+		}
+		if w.Code != http.StatusMovedPermanently && w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("%s %s: unexpected status %d (%s)", method, path, w.Code, w.Body.String())
+		}
+		return strings.SplitN(w.Header().Get("Location"), "/", 4)[2]
+	}
+	for _, name := range []string{"a", "b", "c", "d/e"} {
+		if host := redirect(http.MethodGet, "/v1/files/gs:foo/"+name); host != hrwTarget("gs:foo/"+name) {
+			t.Errorf("gs:foo/%s: redirected to %s, expected %s", name, host, hrwTarget("gs:foo/"+name))
+		}
+		if host := redirect(http.MethodGet, "/v1/files/s3:foo/"+name); host != hrwTarget("foo/"+name) {
+			t.Errorf("s3:foo/%s: redirected to %s, expected %s", name, host, hrwTarget("foo/"+name))
+		}
+		if host := redirect(http.MethodPut, "/v1/files/s3:lb/"+name); host != hrwTarget("lb/"+name) {
+			t.Errorf("s3:lb/%s: redirected to %s, expected %s", name, host, hrwTarget("lb/"+name))
+		}
+	}
+}
+
+func Test_namespacebprops(t *testing.T) {
+	savedbmd, savedsmap, savedconfig := ctx.bmd, ctx.smap, ctx.config
+	defer func() { ctx.bmd, ctx.smap, ctx.config = savedbmd, savedsmap, savedconfig }()
+	ctx.config.Confdir = ""
+	ctx.config.CloudProvider, ctx.config.CloudProviders = amazoncloud, []string{amazoncloud, googlecloud}
+	ctx.bmd = newbucketmd()
+	ctx.smap = &Smap{Smap: make(map[string]*ServerInfo)}
+	p := &proxyrunner{}
+	p.statsif = newstatsregistry()
+	bucketprops := func(method, nsbucket, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.buckethdlr(w, httptest.NewRequest(method, "/"+Rversion+"/"+Rbuckets+"/"+nsbucket, strings.NewReader(body)))
+		return w
+	}
+	if w := bucketprops(http.MethodPut, "gs:foo", `{"read_only": true}`); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set the properties: %d (%s)", w.Code, w.Body.String())
+	}
+	if props := ctx.bmd.getprops("foo"); !props.ReadOnly || props.CloudProvider != googlecloud {
+		t.Errorf("Unexpected properties %+v", props)
+	}
+	var props BucketProps
+	if err := json.Unmarshal(bucketprops(http.MethodGet, "gs:foo", "").Body.Bytes(), &props); err != nil || !props.ReadOnly {
+		t.Errorf("Unexpected properties %+v, err: %v", props, err)
+	}
+	if w := bucketprops(http.MethodPut, "s3:foo", `{"cloud_provider": "gcp"}`); w.Code == http.StatusOK {
+		t.Errorf("Expected the conflicting provider to be rejected")
+	}
+	bucketprops(http.MethodDelete, "gs:foo", "")
+	if props := ctx.bmd.getprops("foo"); props.ReadOnly {
+		t.Errorf("Expected the properties removed, got %+v", props)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pinned objects are never evicted (see lru.go). A pin designates a single object,
+// all objects with a given prefix, or an entire bucket, and may expire.
+// Pins are a part of the bucket metadata: the proxy persists them along with the local
+// buckets and bucket properties, and syncs them with the targets (see syncbmd).
+
+type pin struct {
+	Bucket  string    `json:"bucket"` // see cachebucket
+	Objname string    `json:"objname,omitempty"`
+	Prefix  string    `json:"prefix,omitempty"`
+	Expires time.Time `json:"expires,omitempty"` // zero: never
+}
+
+// the target's current pins, as per the bucket metadata
+type pinmap struct {
+	sync.Mutex
+	Pins []*pin `json:"pins"`
+	gen  int64  // incremented upon every change, see atimerunner.pinnedsize
+}
+
+var pinned = &pinmap{}
+
+func (p *pin) expired(now time.Time) bool {
+	return !p.Expires.IsZero() && now.After(p.Expires)
+}
+
+func (p *pin) same(q *pin) bool {
+	return p.Bucket == q.Bucket && p.Objname == q.Objname && p.Prefix == q.Prefix
+}
+
+func (p *pin) matches(bucket, objname string) bool {
+	if p.Bucket != bucket {
+		return false
+	}
+	switch {
+	case p.Objname != "":
+		return p.Objname == objname
+	case p.Prefix != "":
+		return strings.HasPrefix(objname, p.Prefix)
+	}
+	return true
+}
+
+//====================
+//
+// bucket metadata: adding and removing pins (proxy)
+//
+//====================
+
+// adds or, if the same pin already exists, updates its expiration
+func (m *bucketmd) pin(p *pin) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.prunepins()
+	m.Version++
+	for _, q := range m.Pins {
+		if q.same(p) {
+			q.Expires = p.Expires
+			return
+		}
+	}
+	m.Pins = append(m.Pins, p)
+}
+
+func (m *bucketmd) unpin(p *pin) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.prunepins()
+	for i, q := range m.Pins {
+		if q.same(p) {
+			m.Pins = append(m.Pins[:i], m.Pins[i+1:]...)
+			m.Version++
+			return true
+		}
+	}
+	return false
+}
+
+// NOTE: the caller must hold the lock
+func (m *bucketmd) prunepins() {
+	now := time.Now()
+	pins := m.Pins[:0]
+	for _, p := range m.Pins {
+		if !p.expired(now) {
+			pins = append(pins, p)
+		}
+	}
+	m.Pins = pins
+}
+
+func (m *bucketmd) pinlist() []*pin {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	pins := make([]*pin, len(m.Pins))
+	for i, p := range m.Pins {
+		q := *p
+		pins[i] = &q
+	}
+	return pins
+}
+
+//====================
+//
+// pinmap (target)
+//
+//====================
+
+// replaces the pins upon a bucket metadata update
+func (m *pinmap) set(pins []*pin) {
+	m.Lock()
+	defer m.Unlock()
+	m.Pins = pins
+	m.gen++
+}
+
+func (m *pinmap) ispinned(bucket, objname string) bool {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	for _, p := range m.Pins {
+		if !p.expired(now) && p.matches(bucket, objname) {
+			return true
+		}
+	}
+	return false
+}
+
+// the entire bucket is pinned
+func (m *pinmap) isbucketpinned(bucket string) bool {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	for _, p := range m.Pins {
+		if !p.expired(now) && p.Bucket == bucket && p.Objname == "" && p.Prefix == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// the generation and the earliest future expiration (zero if none)
+func (m *pinmap) state() (gen int64, nextexpiry time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	for _, p := range m.Pins {
+		if !p.expired(now) && !p.Expires.IsZero() && (nextexpiry.IsZero() || p.Expires.Before(nextexpiry)) {
+			nextexpiry = p.Expires
+		}
+	}
+	return m.gen, nextexpiry
+}
+
+// JSON-encoded pins that have not expired
+func (m *pinmap) marshal() []byte {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	out := &pinmap{Pins: make([]*pin, 0, len(m.Pins))}
+	for _, p := range m.Pins {
+		if !p.expired(now) {
+			out.Pins = append(out.Pins, p)
+		}
+	}
+	jsbytes, err := json.Marshal(out)
+	assert(err == nil, err)
+	return jsbytes
+}
+
+// mountpath-relative name (bucket/objname) of a cached object => pinned?
+func pinnedobj(rel string) bool {
+	split := strings.SplitN(rel, "/", 2)
+	return len(split) == 2 && pinned.ispinned(split[0], split[1])
+}
+
+// number and total size of the pinned cached objects: running totals of the access index
+// (see atime.go), recounted only when the pins change or expire
+func init() {
+	storreg.gauge("filespinned", "bytespinned") // cached objects that are never evicted
+}
+
+func pinnedsize() (files, bytes int64) {
+	r := getatimerunner()
+	if r == nil {
+		return
+	}
+	return r.pinnedsize()
+}
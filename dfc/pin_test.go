@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Pins: stored with the bucket metadata and synced with the targets; running totals of the pinned objects.
+//
+// Example run:
+// 	go test -v -run=pin
+//
+package dfc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_pinsync(t *testing.T) {
+	var synced []byte
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/"+Rsyncbmd) {
+			synced, _ = ioutil.ReadAll(r.Body)
+		}
+	}))
+	defer target.Close()
+
+	savedbmd, savedsmap, savedconfig, savedpins := ctx.bmd, ctx.smap, ctx.config, pinned.Pins
+	defer func() { ctx.bmd, ctx.smap, ctx.config = savedbmd, savedsmap, savedconfig; pinned.set(savedpins) }()
+	ctx.config.Confdir = ""
+	ctx.bmd = newbucketmd()
+	ctx.smap = &Smap{Smap: map[string]*ServerInfo{"t1": {DaemonID: "t1", DirectURL: target.URL}}}
+	p := &proxyrunner{}
+	p.httpclient = &http.Client{}
+	p.statsif = newstatsregistry()
+
+	action := func(action, bucket, value string) int {
+		msg := &ActionMsg{Action: action, Param1: bucket, Value: json.RawMessage(value)}
+		w := httptest.NewRecorder()
+		p.pinaction(w, httptest.NewRequest(http.MethodPut, "/"+Rversion+"/"+Rcluster, nil), msg)
+		return w.Code
+	}
+	// the target applies the synced bucket metadata
+	apply := func() {
+		bmd := newbucketmd()
+		if err := json.Unmarshal(synced, bmd); err != nil {
+			t.Fatal(err)
+		}
+		if bmd.Version != ctx.bmd.Version {
+			t.Fatalf("Synced version %d, expected %d", bmd.Version, ctx.bmd.Version)
+		}
+		pinned.set(bmd.Pins)
+	}
+	version := ctx.bmd.Version
+	if code := action(ActionPin, "bucket", `{"prefix": "models/"}`); code != http.StatusOK {
+		t.Fatalf("Failed to pin, status %d", code)
+	}
+	if ctx.bmd.Version == version || synced == nil {
+		t.Fatalf("Expected a new version of the bucket metadata synced with the target")
+	}
+	apply()
+	if !pinned.ispinned("bucket", "models/a") || pinned.ispinned("bucket", "data/a") {
+		t.Errorf("Unexpected pins %+v", pinned.Pins)
+	}
+	if code := action(ActionPin, "bucket", `{"objname": "x", "prefix": "y"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid pin to fail, status %d", code)
+	}
+	if code := action(ActionUnpin, "bucket", `{"prefix": "models/"}`); code != http.StatusOK {
+		t.Fatalf("Failed to unpin, status %d", code)
+	}
+	apply()
+	if pinned.ispinned("bucket", "models/a") || len(ctx.bmd.pinlist()) != 0 {
+		t.Errorf("Expected no pins, got %+v", ctx.bmd.pinlist())
+	}
+	if code := action(ActionUnpin, "bucket", `{"prefix": "models/"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected unpinning a missing pin to fail, status %d", code)
+	}
+	// expired pins are dropped
+	ctx.bmd.pin(&pin{Bucket: "bucket", Expires: time.Now().Add(-time.Second)})
+	ctx.bmd.pin(&pin{Bucket: "other"})
+	if pins := ctx.bmd.pinlist(); len(pins) != 1 || pins[0].Bucket != "other" {
+		t.Errorf("Unexpected pins %+v", pins)
+	}
+}
+
+func Test_pinnedsize(t *testing.T) {
+	mpath, err := ioutil.TempDir("", "pinned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mpath)
+	savedmp, savedpins := ctx.mountpaths, pinned.Pins
+	ctx.mountpaths = map[string]*mountPath{mpath: {Path: mpath}}
+	defer func() { ctx.mountpaths = savedmp; pinned.set(savedpins) }()
+
+	check := func(r *atimerunner, files, bytes int64) {
+		if f, b := r.pinnedsize(); f != files || b != bytes {
+			t.Errorf("Expected %d pinned files, %d bytes, got %d, %d", files, bytes, f, b)
+		}
+	}
+	pinned.set([]*pin{{Bucket: "bucket", Prefix: "p/"}})
+	r := &atimerunner{}
+	r.touch(mpath+"/bucket/p/a", 100)
+	r.touch(mpath+"/bucket/b", 200)
+	check(r, 1, 100)
+	// running totals
+	r.touch(mpath+"/bucket/p/c", 10)
+	r.touch(mpath+"/bucket/p/a", 50)
+	check(r, 2, 60)
+	r.remove(mpath + "/bucket/p/c")
+	check(r, 1, 50)
+	// new pins: recount
+	pinned.set([]*pin{{Bucket: "bucket"}})
+	check(r, 2, 250)
+	// the pin expires: recount
+	pinned.set([]*pin{{Bucket: "bucket", Objname: "b", Expires: time.Now().Add(50 * time.Millisecond)}})
+	check(r, 1, 200)
+	time.Sleep(100 * time.Millisecond)
+	check(r, 0, 0)
+}
@@ -0,0 +1,312 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Prefetch: the proxy resolves the objects to prefetch - a list, a prefix or a regex
+// (over the bucket listing), or a numeric range template - splits them by hrwTarget,
+// and sends each target its share. Each target downloads the objects that are not
+// cached yet by up to Cache.PrefetchWorkers workers, as an XactPrefetch xaction -
+// one per request; concurrent requests run concurrently.
+
+const (
+	prefetchworkers     = 8                // default number of concurrent downloads per target and request
+	prefetchmaxobjs     = 1000000          // max number of objects per request
+	prefetchlisttimeout = 10 * time.Minute // max time to list the cloud bucket (prefix and regex)
+)
+
+// PUT '{"action": "prefetch", "param1": bucket, "value": {PrefetchMsg}}' /v1/cluster
+// PUT '{"action": "prefetch", "value": {PrefetchMsg}}' /v1/files/bucket
+// NOTE: exactly one of the four; targets receive objnames only
+type PrefetchMsg struct {
+	Objnames []string `json:"objnames,omitempty"`
+	Prefix   string   `json:"prefix,omitempty"`
+	Template string   `json:"template,omitempty"` // e.g. "shard-{0000..9999}.tar"
+	Regex    string   `json:"regex,omitempty"`    // matches the object names
+}
+
+var rangetemplate = regexp.MustCompile(`^(.*)\{(\d+)\.\.(\d+)\}(.*)$`)
+
+func parseprefetchmsg(msg *ActionMsg) (prefetchmsg *PrefetchMsg, re *regexp.Regexp, err error) {
+	prefetchmsg = &PrefetchMsg{}
+	if len(msg.Value) > 0 {
+		if err = json.Unmarshal(msg.Value, prefetchmsg); err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse %s value %s, err: %v", msg.Action, string(msg.Value), err)
+		}
+	}
+	n := 0
+	if len(prefetchmsg.Objnames) > 0 {
+		n++
+	}
+	if prefetchmsg.Prefix != "" {
+		n++
+	}
+	if prefetchmsg.Template != "" {
+		n++
+	}
+	if prefetchmsg.Regex != "" {
+		n++
+		if re, err = regexp.Compile(prefetchmsg.Regex); err != nil {
+			return nil, nil, fmt.Errorf("Invalid %s regex %q, err: %v", msg.Action, prefetchmsg.Regex, err)
+		}
+	}
+	if msg.Param1 == "" || n != 1 {
+		return nil, nil, fmt.Errorf("Invalid %s: bucket %q, %+v (expecting bucket and one of objnames, prefix, template, regex)",
+			msg.Action, msg.Param1, *prefetchmsg)
+	}
+	return
+}
+
+// expands "prefix{start..end}suffix"; the numbers are zero-padded to the width of start
+// if the latter has leading zeros
+func expandrange(template string) ([]string, error) {
+	m := rangetemplate.FindStringSubmatch(template)
+	if m == nil {
+		return nil, fmt.Errorf("Invalid range template %q (expecting e.g. shard-{0000..9999}.tar)", template)
+	}
+	start, err1 := strconv.ParseInt(m[2], 10, 64)
+	end, err2 := strconv.ParseInt(m[3], 10, 64)
+	if err1 != nil || err2 != nil || start > end || end-start >= prefetchmaxobjs {
+		return nil, fmt.Errorf("Invalid range {%s..%s} in %q (max %d objects)", m[2], m[3], template, prefetchmaxobjs)
+	}
+	width := 0
+	if len(m[2]) > 1 && m[2][0] == '0' {
+		width = len(m[2])
+	}
+	objnames := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		objnames = append(objnames, fmt.Sprintf("%s%0*d%s", m[1], width, i, m[4]))
+	}
+	return objnames, nil
+}
+
+//===========================
+//
+// proxy
+//
+//===========================
+func (p *proxyrunner) prefetch(w http.ResponseWriter, r *http.Request, msg *ActionMsg) {
+	prefetchmsg, re, err := parseprefetchmsg(msg)
+	if err != nil {
+		p.statsif.add("numerr", 1)
+		invalmsghdlr(w, r, err.Error())
+		return
+	}
+	nsprovider, bucket := parsebucket(msg.Param1)
+	if ctx.bmd.islocal(bucket) {
+		s := fmt.Sprintf("Cannot prefetch local bucket %s", bucket)
+		p.statsif.add("numerr", 1)
+		invalmsghdlr(w, r, s)
+		return
+	}
+	if ctx.smap.count() < 1 {
+		s := errmsgRestApi("No registered targets yet", r)
+		glog.Errorln(s)
+		http.Error(w, s, http.StatusServiceUnavailable)
+		p.statsif.add("numerr", 1)
+		return
+	}
+	// 1. resolve the object names
+	objnames := prefetchmsg.Objnames
+	switch {
+	case prefetchmsg.Template != "":
+		objnames, err = expandrange(prefetchmsg.Template)
+	case prefetchmsg.Prefix != "" || re != nil:
+		objnames, err = p.listcloud(msg.Param1, bucket)
+		objnames = filterobjs(objnames, prefetchmsg.Prefix, re)
+	}
+	if err != nil {
+		p.statsif.add("numerr", 1)
+		invalmsghdlr(w, r, err.Error())
+		return
+	}
+	// 2. split by target
+	shares := make(map[string][]string, ctx.smap.count())
+	for _, objname := range objnames {
+		sid := hrwTarget(cachebucket(nsprovider, bucket) + "/" + objname)
+		shares[sid] = append(shares[sid], objname)
+	}
+	// 3. send each target its share
+	timeout := ctx.config.HttpTimeout
+	if timeout == 0 {
+		timeout = requesttimeout
+	}
+	out := make(map[string]int, len(shares))
+	errs := make(map[string]string)
+	for sid, share := range shares {
+		si := ctx.smap.get(sid)
+		assert(si != nil, "race NIY")
+		value, err := json.Marshal(&PrefetchMsg{Objnames: share})
+		assert(err == nil, err)
+		msgbytes, err := json.Marshal(&ActionMsg{Action: ActionPrefetch, Param1: msg.Param1, Value: value})
+		assert(err == nil, err)
+		url := si.DirectURL + "/" + Rversion + "/" + Rdaemon
+		if _, err = p.calltimeout(url, http.MethodPut, msgbytes, timeout); err != nil {
+			glog.Errorf("Failed to %s %s at %s, err: %v", msg.Action, msg.Param1, sid, err)
+			p.statsif.add("numerr", 1)
+			errs[sid] = err.Error()
+			continue
+		}
+		out[sid] = len(share)
+	}
+	if len(errs) > 0 {
+		s := fmt.Sprintf("Failed to %s %s at %d out of %d targets: %v (accepted: %v)",
+			msg.Action, msg.Param1, len(errs), len(shares), errs, out)
+		invalmsghdlr(w, r, s)
+		return
+	}
+	jsbytes, err := json.Marshal(out)
+	assert(err == nil, err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsbytes)
+}
+
+// lists the cloud bucket via one of the targets (see cinterface.listbucket): one name per line
+func (p *proxyrunner) listcloud(nsbucket, bucket string) ([]string, error) {
+	si := ctx.smap.get(hrwTarget(bucket))
+	assert(si != nil, "race NIY")
+	outbytes, err := p.calltimeout(si.DirectURL+"/"+Rversion+"/"+Rfiles+"/"+nsbucket, http.MethodGet, nil, prefetchlisttimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list bucket %s, err: %v", bucket, err)
+	}
+	lines := strings.Split(string(outbytes), "\n")
+	objnames := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line != "" { // object names may contain spaces
+			objnames = append(objnames, line)
+		}
+	}
+	return objnames, nil
+}
+
+func filterobjs(objnames []string, prefix string, re *regexp.Regexp) []string {
+	out := objnames[:0]
+	for _, objname := range objnames {
+		if prefix != "" && !strings.HasPrefix(objname, prefix) {
+			continue
+		}
+		if re != nil && !re.MatchString(objname) {
+			continue
+		}
+		out = append(out, objname)
+	}
+	return out
+}
+
+//===========================
+//
+// target
+//
+//===========================
+
+// PUT '{"action": "prefetch", "param1": bucket, "value": {"objnames": [...]}}' /v1/daemon
+func (t *targetrunner) prefetchaction(w http.ResponseWriter, r *http.Request, msg *ActionMsg) {
+	prefetchmsg, _, err := parseprefetchmsg(msg)
+	if err == nil && len(prefetchmsg.Objnames) == 0 {
+		err = fmt.Errorf("Invalid %s: expecting objnames", msg.Action)
+	}
+	if err != nil {
+		t.statsif.add("numerr", 1)
+		invalmsghdlr(w, r, err.Error())
+		return
+	}
+	x := xactreg.startmulti(XactPrefetch)
+	x.add("objstotal", int64(len(prefetchmsg.Objnames)))
+	go t.prefetch(x, msg.Param1, prefetchmsg.Objnames)
+}
+
+func (t *targetrunner) prefetch(x *xaction, nsbucket string, objnames []string) {
+	defer xactreg.finish(x)
+	workers := ctx.config.Cache.PrefetchWorkers
+	if workers <= 0 {
+		workers = prefetchworkers
+	}
+	ch := make(chan string, workers)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for objname := range ch {
+				t.prefetchobj(x, nsbucket, objname)
+			}
+		}()
+	}
+	for _, objname := range objnames {
+		if x.aborted() {
+			break
+		}
+		ch <- objname
+	}
+	close(ch)
+	wg.Wait()
+}
+
+func (t *targetrunner) prefetchobj(x *xaction, nsbucket, objname string) {
+	nsprovider, bucket := parsebucket(nsbucket)
+	props := ctx.bmd.getprops(bucket)
+	cloudif, provider := t.getcloudif(nsprovider, &props)
+	if cloudif == nil {
+		glog.Errorf("Cannot prefetch %s/%s: cloud provider %q is not configured", bucket, objname, provider)
+		x.add("objsfailed", 1)
+		return
+	}
+	cbucket := cachebucket(provider, bucket)
+	if fqn, _ := mirrorlookup(cbucket, objname); fqn != "" && t.validcached(cbucket, objname, fqn, &props, false) != "" {
+		x.add("objscached", 1)
+		return
+	}
+	fqn := t.fqn(cbucket, objname)
+	if overquota(cbucket) {
+		if err := quotaevict(cbucket, ""); err != nil {
+			glog.Errorf("Cannot prefetch %s/%s: %v", bucket, objname, err)
+			x.add("objsfailed", 1)
+			return
+		}
+	}
+	if mp := fqn2mountpath(fqn); mp != nil {
+		throttle(mp.Path)
+	}
+	file, err := cloudif.getobj(discardwriter{}, fqn, bucket, objname)
+	if err != nil {
+		getstorstats().addcloud(provider, "numerr", 1)
+		x.add("objsfailed", 1)
+		return
+	}
+	var size int64
+	if finfo, err := file.Stat(); err == nil {
+		size = finfo.Size()
+	}
+	file.Close()
+	t.coldloaded(cbucket, objname, fqn, &props)
+	atimetouch(fqn, size)
+	evictor.access(cbucket+"/"+objname, size, false)
+	if overquota(cbucket) {
+		if err := quotaevict(cbucket, fqn); err != nil {
+			glog.Errorln(err)
+		}
+	}
+	x.add("objsloaded", 1)
+	x.add("bytesloaded", size)
+}
+
+// the cloud interface reports errors via http.ResponseWriter; prefetch has no client to report to
+type discardwriter struct{}
+
+func (discardwriter) Header() http.Header         { return http.Header{} }
+func (discardwriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardwriter) WriteHeader(int)             {}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Prefetch: PrefetchMsg validation, range templates, and listing filters; the proxy's fan-out of the shares.
+//
+// Example run:
+// 	go test -v -run=prefetch
+//
+package dfc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_prefetch(t *testing.T) {
+	for _, value := range []string{`{}`, `{"prefix": "a", "template": "b{1..2}"}`, `{"regex": "["}`} {
+		if _, _, err := parseprefetchmsg(&ActionMsg{Action: ActionPrefetch, Param1: "bucket", Value: []byte(value)}); err == nil {
+			t.Errorf("Expected an error parsing %s", value)
+		}
+	}
+
+	objnames, err := expandrange("shard-{0098..0101}.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objnames) != 4 || objnames[0] != "shard-0098.tar" || objnames[3] != "shard-0101.tar" {
+		t.Errorf("Unexpected expansion %v", objnames)
+	}
+	if objnames, _ = expandrange("{8..10}"); len(objnames) != 3 || objnames[2] != "10" {
+		t.Errorf("Unexpected expansion %v", objnames)
+	}
+	for _, template := range []string{"shard.tar", "shard-{9..1}.tar", "shard-{0..99999999}.tar"} {
+		if _, err = expandrange(template); err == nil {
+			t.Errorf("Expected an error expanding %q", template)
+		}
+	}
+
+	all := []string{"a/1.tar", "a/2.txt", "b/3.tar"}
+	if out := filterobjs(append([]string(nil), all...), "a/", nil); len(out) != 2 {
+		t.Errorf("Prefix: unexpected %v", out)
+	}
+	if out := filterobjs(append([]string(nil), all...), "", regexp.MustCompile(`\.tar$`)); len(out) != 2 || out[1] != "b/3.tar" {
+		t.Errorf("Regex: unexpected %v", out)
+	}
+}
+
+func Test_prefetchfanout(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received = make(map[string][]string)
+	)
+	target := func(sid string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet { // cloud bucket listing
+				w.Write([]byte("dir/with space.tar\ndir/b.tar\nc.txt\n"))
+				return
+			}
+			msg := &ActionMsg{}
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, msg)
+			prefetchmsg := &PrefetchMsg{}
+			json.Unmarshal(msg.Value, prefetchmsg)
+			mu.Lock()
+			received[sid] = append(received[sid], prefetchmsg.Objnames...)
+			mu.Unlock()
+			if status != http.StatusOK {
+				http.Error(w, "rejected", status)
+			}
+		}))
+	}
+	t1, t2 := target("t1", http.StatusOK), target("t2", http.StatusOK)
+	defer t1.Close()
+	defer t2.Close()
+	bad := target("bad", http.StatusBadRequest)
+	defer bad.Close()
+
+	savedsmap, savedbmd := ctx.smap, ctx.bmd
+	defer func() { ctx.smap, ctx.bmd = savedsmap, savedbmd }()
+	ctx.bmd = newbucketmd()
+	ctx.smap = &Smap{Smap: map[string]*ServerInfo{
+		"t1": {DaemonID: "t1", DirectURL: t1.URL},
+		"t2": {DaemonID: "t2", DirectURL: t2.URL},
+	}}
+	p := &proxyrunner{}
+	p.httpclient = &http.Client{}
+	p.statsif = newstatsregistry()
+	prefetch := func(value string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		msg := &ActionMsg{Action: ActionPrefetch, Param1: "bucket", Value: []byte(value)}
+		p.prefetch(w, httptest.NewRequest(http.MethodPut, "/"+Rversion+"/"+Rcluster, nil), msg)
+		return w
+	}
+
+	// the names are listed one per line, spaces included
+	w := prefetch(`{"prefix": "dir/"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Prefetch failed: %d %s", w.Code, w.Body.String())
+	}
+	var all []string
+	for _, objnames := range received {
+		all = append(all, objnames...)
+	}
+	if len(all) != 2 || !strings.Contains(strings.Join(all, "|"), "dir/with space.tar") {
+		t.Errorf("Unexpected objects to prefetch %q", all)
+	}
+
+	// a rejected share is an error, not an accepted one
+	ctx.smap.Smap["bad"] = &ServerInfo{DaemonID: "bad", DirectURL: bad.URL}
+	objnames := make([]string, 64)
+	for i := range objnames {
+		objnames[i] = "obj" + string(rune('A'+i))
+	}
+	value, _ := json.Marshal(&PrefetchMsg{Objnames: objnames})
+	received = make(map[string][]string)
+	if w = prefetch(string(value)); w.Code == http.StatusOK {
+		t.Fatalf("Expected an error, got %d %s", w.Code, w.Body.String())
+	}
+	if len(received["bad"]) == 0 || !strings.Contains(w.Body.String(), "bad") {
+		t.Errorf("Expected the rejected share reported, got %q", w.Body.String())
+	}
+}
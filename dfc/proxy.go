@@ -8,9 +8,14 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -24,10 +29,20 @@ import (
 //===============
 // GET '{"what": "stats"}' /v1/cluster => client
 type Allstats struct {
-	Proxystats Proxystats            `json:"proxystats"`
-	Storstats  map[string]*Storstats `json:"storstats"`
+	Proxystats Stats            `json:"proxystats"`
+	Storstats  map[string]Stats `json:"storstats"`
+	// targets that failed to report: daemon ID => error
+	Errors map[string]string `json:"errors,omitempty"`
+	// counters summed up across the targets that did report
+	Totals map[string]int64 `json:"totals"`
+	// cluster-wide, see bucketstats.go
+	Buckets map[string]map[string]int64 `json:"buckets,omitempty"`
+	Clients map[string]map[string]int64 `json:"clients,omitempty"`
 }
 
+// partial listing of a local bucket (206): JSON-encoded daemon ID => error of the targets that failed
+const HeaderListErrors = "X-DFC-List-Errors"
+
 //===========================================================================
 //
 // proxy runner
@@ -40,12 +55,18 @@ type proxyrunner struct {
 // run
 func (p *proxyrunner) run() error {
 	p.httprunner.init(getproxystats())
+	if err := p.loadbmd(); err != nil {
+		glog.Errorf("Failed to load local buckets, err: %v", err)
+		return err
+	}
 	//
 	// REST API: register proxy handlers and start listening
 	//
 	p.httprunner.registerhdlr("/"+Rversion+"/"+Rfiles+"/", p.filehdlr)
 	p.httprunner.registerhdlr("/"+Rversion+"/"+Rcluster, p.clusterhdlr)
 	p.httprunner.registerhdlr("/"+Rversion+"/"+Rcluster+"/", p.clusterhdlr) // FIXME
+	p.httprunner.registerhdlr("/"+Rversion+"/"+Rbuckets+"/", p.buckethdlr)
+	p.httprunner.registerhdlr("/"+Rmetrics, p.metricshdlr)
 	p.httprunner.registerhdlr("/", invalhdlr)
 	return p.httprunner.run()
 }
@@ -83,12 +104,15 @@ func (p *proxyrunner) filehdlr(w http.ResponseWriter, r *http.Request) {
 		p.httpfilget(w, r)
 	case http.MethodPut:
 		p.httpfilput(w, r)
+	case http.MethodDelete:
+		p.httpfildelete(w, r)
 	default:
 		invalhdlr(w, r)
 	}
 }
 
 func (p *proxyrunner) httpfilget(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
 	p.statsif.add("numget", 1)
 
 	if ctx.smap.count() < 1 {
@@ -102,11 +126,18 @@ func (p *proxyrunner) httpfilget(w http.ResponseWriter, r *http.Request) {
 	if apitems = p.checkRestAPI(w, r, apitems, 1, Rversion, Rfiles); apitems == nil {
 		return
 	}
+	nsprovider, bucket := parsebucket(apitems[0])
+	if len(apitems) == 1 && ctx.bmd.islocal(bucket) {
+		p.listlocalbucket(w, r, bucket)
+		return
+	}
+	// NOTE: the same name that the target uses to place the object, see cachebucket
+	apitems[0] = cachebucket(nsprovider, bucket)
 	sid := hrwTarget(strings.Join(apitems, "/"))
 	si := ctx.smap.get(sid)
 	assert(si != nil, "race NIY")
 
-	redirecturl := si.DirectURL + r.URL.Path
+	redirecturl := targeturl(si, r)
 	if glog.V(3) {
 		glog.Infof("Redirecting %q to %s", r.URL.Path, si.DirectURL)
 	}
@@ -114,8 +145,8 @@ func (p *proxyrunner) httpfilget(w http.ResponseWriter, r *http.Request) {
 		glog.Infoln("Proxy will invoke the GET (ctx.config.Proxy.Passthru = false)")
 		p.receiveDrop(w, r, redirecturl) // ignore error, proceed to http redirect
 	}
-	// FIXME: https, HTTP2 here and elsewhere
 	http.Redirect(w, r, redirecturl, http.StatusMovedPermanently)
+	p.statsif.observe(OpRedirect, time.Since(started))
 }
 
 // receiveDrop reads until EOF and uses dummy writer (ReadToNull)
@@ -123,7 +154,7 @@ func (p *proxyrunner) receiveDrop(w http.ResponseWriter, r *http.Request, redire
 	if glog.V(3) {
 		glog.Infof("GET redirect URL %q", redirecturl)
 	}
-	newr, err := http.Get(redirecturl)
+	newr, err := p.dataclient.Get(redirecturl)
 	if err != nil {
 		glog.Errorf("Failed to GET redirect URL %q, err: %v", redirecturl, err)
 		return err
@@ -147,9 +178,108 @@ func (p *proxyrunner) receiveDrop(w http.ResponseWriter, r *http.Request, redire
 	return err
 }
 
-// 3-way copy
+// objects of the local buckets are spread across all targets
+func (p *proxyrunner) listlocalbucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	timeout := ctx.config.HttpTimeout
+	if timeout == 0 {
+		timeout = requesttimeout
+	}
+	names := make([]string, 0, 64)
+	errs := make(map[string]string)
+	for sid, res := range p.fanout(http.MethodGet, Rfiles+"/"+bucket, nil, timeout) {
+		if res.err != nil {
+			glog.Errorf("Failed to list local bucket %s at %s, err: %v", bucket, sid, res.err)
+			p.statsif.add("numerr", 1)
+			errs[sid] = res.err.Error()
+			continue
+		}
+		for _, name := range strings.Split(string(res.outjson), "\n") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	if len(errs) > 0 {
+		// partial listing: the names from the targets that responded, plus the per-target errors
+		jsbytes, err := json.Marshal(errs)
+		assert(err == nil, err)
+		w.Header().Set(HeaderListErrors, string(jsbytes))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	for _, name := range names {
+		fmt.Fprintln(w, name)
+	}
+}
+
+// PUT /v1/files/bucket/objname (local buckets only) => target
+// PUT '{ActionMsg}' /v1/files/bucket => all targets
 func (p *proxyrunner) httpfilput(w http.ResponseWriter, r *http.Request) {
-	assert(false, "NIY")
+	if apitems := p.restApiItems(r.URL.Path, 5); len(apitems) == 3 {
+		p.bucketaction(w, r, apitems[2])
+		return
+	}
+	p.statsif.add("numput", 1)
+	p.redirectlocal(w, r)
+}
+
+func (p *proxyrunner) bucketaction(w http.ResponseWriter, r *http.Request, bucket string) {
+	var msg ActionMsg
+	if p.readJson(w, r, &msg) != nil {
+		return
+	}
+	msg.Param1 = bucket // NOTE: including the provider namespace, if any
+	switch msg.Action {
+	case ActionEvict:
+		p.evict(w, r, &msg)
+	case ActionPrefetch:
+		p.prefetch(w, r, &msg)
+	default:
+		s := fmt.Sprintf("Unexpected ActionMsg <- JSON [%v]", msg)
+		invalmsghdlr(w, r, s)
+	}
+}
+
+// DELETE /v1/files/bucket/objname (local buckets only) => target
+func (p *proxyrunner) httpfildelete(w http.ResponseWriter, r *http.Request) {
+	p.statsif.add("numdelete", 1)
+	p.redirectlocal(w, r)
+}
+
+// NOTE: 307 rather than 301 to preserve the method and the body
+func (p *proxyrunner) redirectlocal(w http.ResponseWriter, r *http.Request) {
+	if ctx.smap.count() < 1 {
+		s := errmsgRestApi("No registered targets yet", r)
+		glog.Errorln(s)
+		http.Error(w, s, http.StatusServiceUnavailable)
+		p.statsif.add("numerr", 1)
+		return
+	}
+	apitems := p.restApiItems(r.URL.Path, 5)
+	if apitems = p.checkRestAPI(w, r, apitems, 2, Rversion, Rfiles); apitems == nil {
+		return
+	}
+	_, apitems[0] = parsebucket(apitems[0]) // NOTE: provider namespace, if any, is not a part of the name
+	if !ctx.bmd.islocal(apitems[0]) {
+		s := fmt.Sprintf("%s is supported only for local buckets (%s is not)", r.Method, apitems[0])
+		p.statsif.add("numerr", 1)
+		invalmsghdlr(w, r, s)
+		return
+	}
+	if props := ctx.bmd.getprops(apitems[0]); props.ReadOnly {
+		s := errmsgRestApi("Bucket "+apitems[0]+" is read-only", r)
+		p.statsif.add("numerr", 1)
+		glog.Errorln(s)
+		http.Error(w, s, http.StatusForbidden)
+		return
+	}
+	sid := hrwTarget(strings.Join(apitems, "/"))
+	si := ctx.smap.get(sid)
+	assert(si != nil, "race NIY")
+	if glog.V(3) {
+		glog.Infof("Redirecting %s %q to %s", r.Method, r.URL.Path, si.DirectURL)
+	}
+	http.Redirect(w, r, targeturl(si, r), http.StatusTemporaryRedirect)
 }
 
 //===========================
@@ -195,25 +325,97 @@ func (p *proxyrunner) httpcluget(w http.ResponseWriter, r *http.Request) {
 		getstatsmsg, err := json.Marshal(msg) // same message to all targets
 		assert(err == nil, err)
 		p.httpclugetstats(w, r, getstatsmsg)
+	case GetBucketMD:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(ctx.bmd.marshal())
+	case GetCapacity:
+		jsbytes, err := json.Marshal(p.getcapacity(&msg))
+		assert(err == nil, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsbytes)
+	case GetHistory:
+		names, since, err := parsehistorymsg(&msg)
+		if err != nil {
+			invalmsghdlr(w, r, err.Error())
+			return
+		}
+		out := struct {
+			Proxy   *Statshistory              `json:"proxy"`
+			Targets map[string]json.RawMessage `json:"targets"`
+			Errors  map[string]string          `json:"errors,omitempty"` // the targets that failed or timed out
+		}{Proxy: getproxystatsrunner().gethistory(names, since)}
+		out.Targets, out.Errors = p.getall(&msg)
+		jsbytes, err := json.Marshal(&out)
+		assert(err == nil, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsbytes)
+	case GetXactions:
+		out := struct {
+			Proxy   []*XactStatus              `json:"proxy"`
+			Targets map[string]json.RawMessage `json:"targets"`
+			Errors  map[string]string          `json:"errors,omitempty"`
+		}{Proxy: xactreg.list()}
+		out.Targets, out.Errors = p.getall(&msg)
+		jsbytes, err := json.Marshal(&out)
+		assert(err == nil, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsbytes)
+	case GetLRU:
+		out, errs := p.getall(&msg)
+		for sid, s := range errs {
+			jsbytes, err := json.Marshal(&struct {
+				Error string `json:"error"`
+			}{s})
+			assert(err == nil, err)
+			out[sid] = jsbytes
+		}
+		jsbytes, err := json.Marshal(out)
+		assert(err == nil, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsbytes)
 	default:
 		s := fmt.Sprintf("Unexpected GetMsg <- JSON [%v]", msg)
 		invalmsghdlr(w, r, s)
 	}
 }
 
-// FIXME: run this in a goroutine
+// queries all targets in parallel; a target that fails or does not respond
+// within Proxy.StatsTimeout is reported in Allstats.Errors
 func (p *proxyrunner) httpclugetstats(w http.ResponseWriter, r *http.Request, getstatsmsg []byte) {
-	var out Allstats
-	out.Storstats = make(map[string]*Storstats, len(ctx.smap.Smap))
-	getproxystatsrunner().syncstats(&out.Proxystats)
-	for _, si := range ctx.smap.Smap {
-		stats := Storstats{}
-		out.Storstats[si.DaemonID] = &stats
-		url := si.DirectURL + "/" + Rversion + "/" + Rdaemon
-		outjson, err := p.call(url, r.Method, getstatsmsg)
-		assert(err == nil, err)
-		err = json.Unmarshal(outjson, &stats)
-		assert(err == nil, err)
+	timeout := ctx.config.Proxy.StatsTimeout
+	if timeout == 0 {
+		timeout = requesttimeout
+	}
+	results := p.fanout(r.Method, Rdaemon, getstatsmsg, timeout)
+	out := Allstats{
+		Proxystats: getproxystatsrunner().getstats(),
+		Storstats:  make(map[string]Stats, len(results)),
+		Errors:     make(map[string]string),
+		Totals:     make(map[string]int64),
+		Buckets:    make(map[string]map[string]int64),
+		Clients:    make(map[string]map[string]int64),
+	}
+	for sid, res := range results {
+		stats := Stats{}
+		err := res.err
+		if err == nil {
+			err = json.Unmarshal(res.outjson, &stats)
+		}
+		if err != nil {
+			glog.Errorf("Failed to get stats from %s, err: %v", sid, err)
+			out.Errors[sid] = err.Error()
+			continue
+		}
+		out.Storstats[sid] = stats
+		addtotals(out.Totals, stats)
+		var breakdowns struct {
+			Buckets map[string]map[string]int64 `json:"buckets"`
+			Clients map[string]map[string]int64 `json:"clients"`
+		}
+		if err = json.Unmarshal(res.outjson, &breakdowns); err == nil {
+			addbreakdown(out.Buckets, breakdowns.Buckets)
+			addbreakdown(out.Clients, breakdowns.Clients)
+		}
 	}
 	jsbytes, err := json.Marshal(&out)
 	assert(err == nil, err)
@@ -221,6 +423,124 @@ func (p *proxyrunner) httpclugetstats(w http.ResponseWriter, r *http.Request, ge
 	w.Write(jsbytes)
 }
 
+// target stats that are not summed up: derived values and sections aggregated separately
+var nototals = map[string]bool{"hitratio": true, "latency": true, "rates": true, "quotas": true,
+	"buckets": true, "clients": true}
+
+// sums up a target's counters and gauges, including the nested ones (e.g., "aws.numcoldget")
+func addtotals(totals map[string]int64, stats Stats) {
+	for name, v := range stats {
+		if nototals[name] {
+			continue
+		}
+		switch val := v.(type) {
+		case float64:
+			totals[name] += int64(val)
+		case map[string]interface{}:
+			for sub, subv := range val {
+				if subval, ok := subv.(float64); ok {
+					totals[name+"."+sub] += int64(subval)
+				}
+			}
+		}
+	}
+}
+
+// PUT the same ActionMsg to all targets; returns daemon ID => response, nil on failure
+// sends the action to all targets in parallel (timeout 0: Config.HttpTimeout);
+// returns daemon ID => response, and daemon ID => error for the targets that failed
+func (p *proxyrunner) broadcast(msg *ActionMsg, timeout time.Duration) (out map[string][]byte, errs map[string]string) {
+	msgbytes, err := json.Marshal(msg)
+	assert(err == nil, err)
+	if timeout == 0 {
+		timeout = ctx.config.HttpTimeout
+	}
+	if timeout == 0 {
+		timeout = requesttimeout
+	}
+	out, errs = make(map[string][]byte, len(ctx.smap.Smap)), make(map[string]string)
+	for sid, res := range p.fanout(http.MethodPut, Rdaemon, msgbytes, timeout) {
+		if res.err != nil {
+			glog.Errorf("Failed to %s %s at %s, err: %v", msg.Action, msg.Param1, sid, res.err)
+			p.statsif.add("numerr", 1)
+			errs[sid] = res.err.Error()
+			continue
+		}
+		out[sid] = res.outjson
+	}
+	return
+}
+
+// broadcast, failing the request if any target fails
+func (p *proxyrunner) broadcastall(w http.ResponseWriter, r *http.Request, msg *ActionMsg) {
+	out, errs := p.broadcast(msg, 0)
+	if len(errs) == 0 {
+		return
+	}
+	succeeded := make([]string, 0, len(out))
+	for sid := range out {
+		succeeded = append(succeeded, sid)
+	}
+	sort.Strings(succeeded)
+	s := fmt.Sprintf("Failed to %s %s at %d out of %d targets: %v (succeeded: %v)",
+		msg.Action, msg.Param1, len(errs), len(errs)+len(out), errs, succeeded)
+	invalmsghdlr(w, r, s)
+}
+
+type fanoutresult struct {
+	outjson []byte
+	err     error
+}
+
+// sends the same request to all targets in parallel, each call bounded by the given timeout;
+// returns daemon ID => response or error (including an HTTP error status)
+func (p *proxyrunner) fanout(method, path string, injson []byte, timeout time.Duration) map[string]*fanoutresult {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		out = make(map[string]*fanoutresult, len(ctx.smap.Smap))
+	)
+	for _, si := range ctx.smap.Smap {
+		wg.Add(1)
+		go func(si *ServerInfo) {
+			defer wg.Done()
+			url := si.DirectURL + "/" + Rversion + "/" + path
+			outjson, err := p.calltimeout(url, method, injson, timeout)
+			mu.Lock()
+			out[si.DaemonID] = &fanoutresult{outjson: outjson, err: err}
+			mu.Unlock()
+		}(si)
+	}
+	wg.Wait()
+	return out
+}
+
+// GET the same GetMsg from all targets in parallel, each bounded by Proxy.StatsTimeout;
+// returns daemon ID => JSON response, and daemon ID => error for the rest
+func (p *proxyrunner) getall(msg *GetMsg) (out map[string]json.RawMessage, errs map[string]string) {
+	msgbytes, err := json.Marshal(msg)
+	assert(err == nil, err)
+	timeout := ctx.config.Proxy.StatsTimeout
+	if timeout == 0 {
+		timeout = requesttimeout
+	}
+	out, errs = make(map[string]json.RawMessage, len(ctx.smap.Smap)), make(map[string]string)
+	for sid, res := range p.fanout(http.MethodGet, Rdaemon, msgbytes, timeout) {
+		err := res.err
+		if err == nil && !json.Valid(res.outjson) {
+			err = fmt.Errorf("invalid JSON response %q", string(res.outjson))
+		}
+		if err != nil {
+			glog.Errorf("Failed to get %s from %s, err: %v", msg.What, sid, err)
+			p.statsif.add("numerr", 1)
+			errs[sid] = err.Error()
+			continue
+		}
+		out[sid] = res.outjson
+	}
+	return
+}
+
 // registers a new target
 func (p *proxyrunner) httpclupost(w http.ResponseWriter, r *http.Request) {
 	apitems := p.restApiItems(r.URL.Path, 5)
@@ -246,6 +566,9 @@ func (p *proxyrunner) httpclupost(w http.ResponseWriter, r *http.Request) {
 	if glog.V(3) {
 		glog.Infof("Registered target {%s} (count %d)", si.DaemonID, ctx.smap.count())
 	}
+	// the new target receives the current local buckets in response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(ctx.bmd.marshal())
 }
 
 // unregisters a target
@@ -294,16 +617,227 @@ func (p *proxyrunner) httpcluput(w http.ResponseWriter, r *http.Request) {
 
 	case ActionSyncSmap:
 		// PUT '{"action": "syncsmap"}' /v1/cluster => (proxy) => PUT '{Smap}' /v1/daemon/syncsmap => target(s)
+		x, err := xactreg.start(XactSyncSmap)
+		if err != nil {
+			invalmsghdlr(w, r, err.Error())
+			return
+		}
 		jsbytes, err := json.Marshal(ctx.smap)
 		assert(err == nil, err)
 		for _, si := range ctx.smap.Smap {
+			if x.aborted() {
+				break
+			}
 			url := si.DirectURL + "/" + Rversion + "/" + Rdaemon + "/" + Rsyncsmap
 			_, err := p.call(url, r.Method, jsbytes)
 			assert(err == nil, err)
+			x.add("targetssynced", 1)
 		}
+		// bucket metadata goes along with the Smap
+		if !x.aborted() {
+			p.syncbmd()
+		}
+		xactreg.finish(x)
+
+	case ActionCreateLB, ActionDestroyLB:
+		bucket := msg.Param1
+		if bucket == "" {
+			invalmsghdlr(w, r, "Missing local bucket name (param1)")
+			return
+		}
+		if msg.Action == ActionCreateLB && !ctx.bmd.add(bucket) {
+			invalmsghdlr(w, r, fmt.Sprintf("Cannot %s: local bucket %s already exists", msg.Action, bucket))
+			return
+		}
+		if msg.Action == ActionDestroyLB && !ctx.bmd.del(bucket) {
+			invalmsghdlr(w, r, fmt.Sprintf("Cannot %s: local bucket %s does not exist", msg.Action, bucket))
+			return
+		}
+		if err := p.savebmd(); err != nil {
+			glog.Errorf("Failed to store bucket metadata, err: %v", err)
+		}
+		p.syncbmd()
+
+	case ActionPin, ActionUnpin:
+		p.pinaction(w, r, &msg)
+
+	case ActionLRU:
+		p.broadcastall(w, r, &msg)
+
+	case ActionAbortXact:
+		if msg.Param1 == "" {
+			invalmsghdlr(w, r, "Missing xaction ID or kind (param1)")
+			return
+		}
+		xactreg.abort(msg.Param1) // the proxy's own, if any
+		p.broadcastall(w, r, &msg)
+
+	case ActionEvict:
+		p.evict(w, r, &msg)
+
+	case ActionPrefetch:
+		p.prefetch(w, r, &msg)
 
 	default:
 		s := fmt.Sprintf("Unexpected ActionMsg <- JSON [%v]", msg)
 		invalmsghdlr(w, r, s)
 	}
 }
+
+//===========================
+//
+// bucket properties
+//
+//===========================
+
+// handler for: "/"+Rversion+"/"+Rbuckets+"/"+bucket
+func (p *proxyrunner) buckethdlr(w http.ResponseWriter, r *http.Request) {
+	apitems := p.restApiItems(r.URL.Path, 5)
+	if apitems = p.checkRestAPI(w, r, apitems, 1, Rversion, Rbuckets); apitems == nil {
+		return
+	}
+	nsprovider, bucket := parsebucket(apitems[0]) // the properties are stored under the bucket name
+	switch r.Method {
+	case http.MethodGet:
+		props := ctx.bmd.getprops(bucket)
+		jsbytes, err := json.Marshal(&props)
+		assert(err == nil, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsbytes)
+		return
+	case http.MethodPut:
+		var props BucketProps
+		if p.readJson(w, r, &props) != nil {
+			return
+		}
+		if props.CloudProvider == "" && nsprovider != "" && !ctx.bmd.islocal(bucket) {
+			props.CloudProvider = nsprovider
+		}
+		if err := validatebprops(nsprovider, bucket, &props); err != nil {
+			p.statsif.add("numerr", 1)
+			invalmsghdlr(w, r, err.Error())
+			return
+		}
+		ctx.bmd.setprops(bucket, &props)
+	case http.MethodDelete:
+		ctx.bmd.setprops(bucket, nil)
+	default:
+		invalhdlr(w, r)
+		return
+	}
+	if err := p.savebmd(); err != nil {
+		glog.Errorf("Failed to store bucket metadata, err: %v", err)
+	}
+	p.syncbmd()
+	glog.Flush()
+}
+
+func validatebprops(nsprovider, bucket string, props *BucketProps) error {
+	if props.CloudProvider != "" && !isprovider(props.CloudProvider) {
+		return fmt.Errorf("Invalid cloud provider %q (expecting one of the %v)", props.CloudProvider, ctx.config.CloudProviders)
+	}
+	if nsprovider != "" && props.CloudProvider != "" && props.CloudProvider != nsprovider {
+		return fmt.Errorf("Bucket %s: cloud provider %q conflicts with the namespace %q", bucket, props.CloudProvider, nsprovider)
+	}
+	if props.CloudProvider != "" && ctx.bmd.islocal(bucket) {
+		return fmt.Errorf("Local bucket %s cannot have a cloud provider", bucket)
+	}
+	switch props.Checksum {
+	case "", ChecksumNone, ChecksumXXHash:
+	default:
+		return fmt.Errorf("Invalid checksum %q (expecting %q or %q)", props.Checksum, ChecksumNone, ChecksumXXHash)
+	}
+	if props.TTL < 0 || props.Mirror < 0 {
+		return fmt.Errorf("Invalid TTL %v or number of local copies %d", props.TTL, props.Mirror)
+	}
+	if props.Quota < 0 || (props.Quota > 0 && ctx.bmd.islocal(bucket)) {
+		return fmt.Errorf("Invalid quota %d: must be positive and is only supported for cloud buckets", props.Quota)
+	}
+	return nil
+}
+
+// PUT '{"action": "pin"|"unpin", "param1": bucket, "value": {PinMsg}}' /v1/cluster
+// pins are stored with the bucket metadata and synced with all targets, including the future ones
+func (p *proxyrunner) pinaction(w http.ResponseWriter, r *http.Request, msg *ActionMsg) {
+	var pinmsg PinMsg
+	if len(msg.Value) > 0 {
+		if err := json.Unmarshal(msg.Value, &pinmsg); err != nil {
+			s := fmt.Sprintf("Failed to parse %s value %s, err: %v", msg.Action, string(msg.Value), err)
+			invalmsghdlr(w, r, s)
+			return
+		}
+	}
+	if msg.Param1 == "" || (pinmsg.Objname != "" && pinmsg.Prefix != "") {
+		s := fmt.Sprintf("Invalid %s: bucket %q, objname %q, prefix %q (expecting bucket and at most one of the two)",
+			msg.Action, msg.Param1, pinmsg.Objname, pinmsg.Prefix)
+		invalmsghdlr(w, r, s)
+		return
+	}
+	pn := &pin{Bucket: cachebucket(parsebucket(msg.Param1)), Objname: pinmsg.Objname, Prefix: pinmsg.Prefix}
+	if msg.Action == ActionPin {
+		if pinmsg.TTL > 0 {
+			pn.Expires = time.Now().Add(pinmsg.TTL)
+		}
+		ctx.bmd.pin(pn)
+	} else if !ctx.bmd.unpin(pn) {
+		s := fmt.Sprintf("Cannot unpin %+v: not pinned", *pn)
+		invalmsghdlr(w, r, s)
+		return
+	}
+	glog.Infof("%s: %+v", msg.Action, *pn)
+	if err := p.savebmd(); err != nil {
+		glog.Errorf("Failed to store bucket metadata, err: %v", err)
+	}
+	p.syncbmd()
+}
+
+//===========================
+//
+// bucket metadata: persistence and distribution
+//
+//===========================
+func bmdfile() string {
+	return filepath.Join(ctx.config.Confdir, "bucketmd.json")
+}
+
+func (p *proxyrunner) loadbmd() error {
+	if ctx.config.Confdir == "" {
+		return nil
+	}
+	jsbytes, err := ioutil.ReadFile(bmdfile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	bmd := newbucketmd()
+	if err = json.Unmarshal(jsbytes, bmd); err != nil {
+		return err
+	}
+	ctx.bmd.update(bmd)
+	glog.Infof("Loaded bucket metadata: %d local buckets, %d with properties, %d pins (version %d)",
+		len(bmd.LBmap), len(bmd.BProps), len(bmd.Pins), bmd.Version)
+	return nil
+}
+
+func (p *proxyrunner) savebmd() error {
+	if ctx.config.Confdir == "" {
+		return nil
+	}
+	if err := CreateDir(ctx.config.Confdir); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(bmdfile(), ctx.bmd.marshal(), 0644)
+}
+
+// PUT '{bucketmd}' /v1/daemon/syncbmd => target(s)
+func (p *proxyrunner) syncbmd() {
+	jsbytes := ctx.bmd.marshal()
+	for _, si := range ctx.smap.Smap {
+		url := si.DirectURL + "/" + Rversion + "/" + Rdaemon + "/" + Rsyncbmd
+		if _, err := p.call(url, http.MethodPut, jsbytes); err != nil {
+			glog.Errorf("Failed to sync bucket metadata with %s, err: %v", si.DaemonID, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Per-bucket quotas: BucketProps.Quota limits the total size of the bucket's
+// cached objects on each target. The limit is enforced at fill time (cold GET), before
+// and after the fill, by evicting the bucket's own objects in the eviction policy order
+// (subject to the same exclusions as the watermark-driven eviction); a fill that cannot make
+// enough room fails;
+// watermark-driven eviction (see lru.go) reclaims from the buckets over their quotas first.
+// The usage comes from the access index (see atime.go) that is seeded at startup by
+// walking the mountpaths.
+
+type Quotastats struct {
+	Quota int64 `json:"quota"`
+	Used  int64 `json:"used"`
+}
+
+var quotaevicting = struct {
+	sync.Mutex
+	buckets map[string]bool
+}{buckets: make(map[string]bool)}
+
+func overquota(bucket string) bool {
+	r := getatimerunner()
+	if r == nil || ctx.bmd == nil {
+		return false
+	}
+	quota := bucketquota(bucket)
+	return quota > 0 && r.bucketusage(bucket) > quota
+}
+
+// NOTE: bucket is the name the objects are cached under, see cachebucket
+func bucketquota(bucket string) int64 {
+	_, bucket = parsebucket(bucket)
+	return ctx.bmd.getprops(bucket).Quota
+}
+
+// quota usage of the buckets that have quotas
+func quotausage() map[string]*Quotastats {
+	r := getatimerunner()
+	if r == nil {
+		return nil
+	}
+	quotas := ctx.bmd.quotas()
+	if len(quotas) == 0 {
+		return nil
+	}
+	usage := make(map[string]*Quotastats, len(quotas))
+	for bucket, quota := range quotas {
+		usage[bucket] = &Quotastats{Quota: quota, Used: r.bucketusage(cachebucket("", bucket))}
+	}
+	return usage
+}
+
+// evicts the bucket's objects until its usage is within the quota; skips the just loaded
+// exceptfqn and, same as lrupush, the pinned objects, the objects used within Cache.DontEvictTime
+// and the buckets with eviction disabled; returns an error if the bucket remains over its quota
+func quotaevict(bucket, exceptfqn string) error {
+	quotaevicting.Lock()
+	if quotaevicting.buckets[bucket] {
+		quotaevicting.Unlock()
+		return nil
+	}
+	quotaevicting.buckets[bucket] = true
+	quotaevicting.Unlock()
+	defer func() {
+		quotaevicting.Lock()
+		delete(quotaevicting.buckets, bucket)
+		quotaevicting.Unlock()
+	}()
+
+	r := getatimerunner()
+	quota := bucketquota(bucket)
+	toevict := r.bucketusage(bucket) - quota
+	if quota <= 0 || toevict <= 0 {
+		return nil
+	}
+	if !bucketevictable(bucket) {
+		return fmt.Errorf("Bucket %s is over its quota %d (usage %d): eviction is disabled", bucket, quota, quota+toevict)
+	}
+	dontevictime := time.Now().Add(-ctx.config.Cache.DontEvictTime)
+	fis := r.bucketsnapshot(bucket)
+	candidates := fis[:0]
+	for _, fi := range fis {
+		if fi.fqn == exceptfqn || fi.usetime.After(dontevictime) || pinned.ispinned(bucket, fi.objkey[len(bucket)+1:]) {
+			continue
+		}
+		fi.key = evictor.key(fi)
+		candidates = append(candidates, fi)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return evictsbefore(candidates[i], candidates[j]) })
+
+	var fevicted, bevicted int64
+	for _, fi := range candidates {
+		if toevict <= 0 {
+			break
+		}
+		if mp := fqn2mountpath(fi.fqn); mp != nil {
+			throttle(mp.Path)
+		}
+		if err := os.Remove(fi.fqn); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("Failed to evict %q, err: %v", fi.fqn, err)
+			continue
+		}
+		r.remove(fi.fqn)
+		evictor.evicted(fi)
+		toevict -= fi.size
+		bevicted += fi.size
+		fevicted++
+	}
+	stats := getstorstats()
+	stats.add("bytesevicted", bevicted)
+	bucketstats.add(bucket, "bytesevicted", bevicted)
+	stats.add("filesevicted", fevicted)
+	glog.Infof("Bucket %s quota %d: evicted %d files, %d bytes", bucket, quota, fevicted, bevicted)
+	if toevict > 0 {
+		return fmt.Errorf("Bucket %s is over its quota %d by %d bytes: not enough evictable objects", bucket, quota, toevict)
+	}
+	return nil
+}
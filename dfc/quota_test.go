@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Bucket quotas: usage seeded by the startup walk, enforcement before the cold GET,
+// the objects and buckets that quota eviction skips.
+//
+// Example run:
+// 	go test -v -run=quota
+//
+package dfc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writes the object and backdates its access and modification times
+func quotaobj(t *testing.T, fqn string, size int, age time.Duration) {
+	if err := os.MkdirAll(filepath.Dir(fqn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fqn, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tm := time.Now().Add(-age)
+	if err := os.Chtimes(fqn, tm, tm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func quotasetup(t *testing.T, quota int64) (mpath string, r *atimerunner) {
+	mpath, err := ioutil.TempDir("", "quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.mountpaths = map[string]*mountPath{mpath: {Path: mpath}}
+	ctx.bmd = newbucketmd()
+	ctx.bmd.add("lb")
+	ctx.bmd.setprops("bucket", &BucketProps{Quota: quota})
+	r = &atimerunner{}
+	ctx.rg = &rungroup{runmap: map[string]runner{xatime: r}}
+	return
+}
+
+func Test_quotaseed(t *testing.T) {
+	savedmp, savedbmd, savedrg := ctx.mountpaths, ctx.bmd, ctx.rg
+	defer func() { ctx.mountpaths, ctx.bmd, ctx.rg = savedmp, savedbmd, savedrg }()
+	mpath, r := quotasetup(t, 100)
+	defer os.RemoveAll(mpath)
+
+	// cached before the restart, with no access index
+	quotaobj(t, mpath+"/bucket/a", 60, time.Hour)
+	quotaobj(t, mpath+"/bucket/dir/b", 70, time.Minute)
+	quotaobj(t, mpath+"/lb/c", 1000, time.Minute)
+	quotaobj(t, mpath+"/.ec/slice/bucket/d", 1000, time.Minute)
+	r.load()
+	if overquota("bucket") {
+		t.Fatalf("Expected no usage before the walk")
+	}
+	r.seedall()
+	if usage := r.bucketusage("bucket"); usage != 130 {
+		t.Errorf("Expected bucket usage 130, got %d", usage)
+	}
+	if usage := r.bucketusage("lb"); usage != 0 {
+		t.Errorf("Local buckets are not indexed, got usage %d", usage)
+	}
+	if !overquota("bucket") {
+		t.Errorf("Expected the bucket to be over its quota")
+	}
+	// seeding again does not double count
+	r.seedall()
+	if usage := r.bucketusage("bucket"); usage != 130 {
+		t.Errorf("Expected bucket usage 130, got %d", usage)
+	}
+	quotaevict("bucket", "")
+	if _, err := os.Stat(mpath + "/bucket/a"); !os.IsNotExist(err) {
+		t.Errorf("Expected the least recently used object to be evicted, err: %v", err)
+	}
+	if usage := r.bucketusage("bucket"); usage != 70 {
+		t.Errorf("Expected bucket usage 70, got %d", usage)
+	}
+}
+
+// cinterface: "downloads" objects of a given size, recording the bucket usage at the time
+type quotacloud struct {
+	size  int
+	usage int64
+}
+
+func (c *quotacloud) listbucket(w http.ResponseWriter, bucket string) error { return nil }
+
+func (c *quotacloud) getobj(w http.ResponseWriter, fqn, bucket, objname string) (*os.File, error) {
+	c.usage = getatimerunner().bucketusage(bucket)
+	if err := os.MkdirAll(filepath.Dir(fqn), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(fqn, make([]byte, c.size), 0644); err != nil {
+		return nil, err
+	}
+	return os.Open(fqn)
+}
+
+func Test_quotacoldget(t *testing.T) {
+	savedmp, savedbmd, savedrg, savedprovider := ctx.mountpaths, ctx.bmd, ctx.rg, ctx.config.CloudProvider
+	defer func() {
+		ctx.mountpaths, ctx.bmd, ctx.rg, ctx.config.CloudProvider = savedmp, savedbmd, savedrg, savedprovider
+	}()
+	mpath, r := quotasetup(t, 100)
+	defer os.RemoveAll(mpath)
+	ctx.config.CloudProvider = amazoncloud
+
+	quotaobj(t, mpath+"/bucket/a", 80, time.Hour)
+	quotaobj(t, mpath+"/bucket/b", 80, time.Minute)
+	r.seedall()
+
+	cloud := &quotacloud{size: 10}
+	tr := &targetrunner{cloudifs: map[string]cinterface{amazoncloud: cloud}}
+	tr.statsif = newstatsregistry()
+	w := httptest.NewRecorder()
+	tr.httpfilget(w, httptest.NewRequest(http.MethodGet, "/"+Rversion+"/"+Rfiles+"/bucket/c", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != cloud.size {
+		t.Fatalf("Cold GET failed: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if cloud.usage > 100 {
+		t.Errorf("Expected the quota to be enforced before the cold GET, usage %d", cloud.usage)
+	}
+	if _, err := os.Stat(mpath + "/bucket/a"); !os.IsNotExist(err) {
+		t.Errorf("Expected the least recently used object to be evicted, err: %v", err)
+	}
+	if _, err := os.Stat(mpath + "/bucket/b"); err != nil {
+		t.Errorf("Expected the more recently used object to stay, err: %v", err)
+	}
+}
+
+func Test_quotanoevict(t *testing.T) {
+	savedmp, savedbmd, savedrg, savedprovider, savedcache := ctx.mountpaths, ctx.bmd, ctx.rg, ctx.config.CloudProvider, ctx.config.Cache
+	defer func() {
+		ctx.mountpaths, ctx.bmd, ctx.rg, ctx.config.CloudProvider, ctx.config.Cache = savedmp, savedbmd, savedrg, savedprovider, savedcache
+	}()
+	mpath, r := quotasetup(t, 100)
+	defer os.RemoveAll(mpath)
+	ctx.config.CloudProvider = amazoncloud
+	ctx.config.Cache.DontEvictTime = 10 * time.Minute
+
+	// the recently used object is not evictable: the cold GET fails
+	quotaobj(t, mpath+"/bucket/a", 80, time.Hour)
+	quotaobj(t, mpath+"/bucket/b", 120, time.Minute)
+	r.seedall()
+	cloud := &quotacloud{size: 10}
+	tr := &targetrunner{cloudifs: map[string]cinterface{amazoncloud: cloud}}
+	tr.statsif = newstatsregistry()
+	w := httptest.NewRecorder()
+	tr.httpfilget(w, httptest.NewRequest(http.MethodGet, "/"+Rversion+"/"+Rfiles+"/bucket/c", nil))
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("Expected the cold GET to fail with %d, got %d", http.StatusInsufficientStorage, w.Code)
+	}
+	if _, err := os.Stat(mpath + "/bucket/a"); !os.IsNotExist(err) {
+		t.Errorf("Expected the least recently used object to be evicted, err: %v", err)
+	}
+	if _, err := os.Stat(mpath + "/bucket/b"); err != nil {
+		t.Errorf("Expected the recently used object to stay, err: %v", err)
+	}
+
+	// eviction disabled
+	ctx.bmd.setprops("bucket", &BucketProps{Quota: 50, NoEviction: true})
+	if err := quotaevict("bucket", ""); err == nil {
+		t.Errorf("Expected an error evicting from a bucket with eviction disabled")
+	}
+	if _, err := os.Stat(mpath + "/bucket/b"); err != nil {
+		t.Errorf("Expected no eviction, err: %v", err)
+	}
+}
@@ -11,6 +11,15 @@ INSTANCEPREFIX="dfc"
 TMPDIR="/tmp/nvidia"
 CACHEDIR="cache"
 LOGDIR="log"
+# HTTPS: set TLSENABLED=true, point the following to PEM files, and change PROXYURL to https://
+TLSENABLED=false
+TLSCERT=""
+TLSKEY=""
+TLSCA=""
+TLSMUTUAL=false
+# token-based authentication: set AUTHENABLED=true and a secret shared by all daemons
+AUTHENABLED=false
+AUTHSECRET=""
 PROXYURL="http://localhost:8080"
 PASSTHRU=true
 
@@ -35,9 +44,35 @@ CACHEDIR="/cache"
 ERRORTHRESHOLD=5
 STATSTIMESEC=10
 HTTPTIMEOUTSEC=60
+STATSTIMEOUTSEC=5
 DONTEVICTIMESEC=600
+# eviction policy: lru, lfu, gdsf, or arc
+EVICTPOLICY="lru"
+ATIMEFLUSHTIMESEC=60
+# TTL of the cached objects (0: never expire) and the expiry job interval (0: expire on access only)
+OBJECTTTLSEC=0
+EXPIRYTIMESEC=600
+PREFETCHWORKERS=8
 FSLOWWATERMARK=65
 FSHIGHWATERMARK=80
+# local mirroring: number of copies of each object across mountpaths (1: no mirroring)
+MIRRORCOPIES=1
+# erasure coding of locally written objects
+ECENABLED=false
+ECDATASLICES=2
+ECPARITYSLICES=1
+ECOBJSIZELIMIT=262144
+# throttling of the background jobs (eviction, mirror restoration, EC rebuild)
+THROTTLEUTILLOW=60
+THROTTLEUTILHIGH=90
+THROTTLELATENCYHIGHMS=0
+THROTTLESLEEPMAXMS=10
+THROTTLEMAXOPS=0
+# stats history kept in memory: snapshot interval and retention
+HISTORYRESOLUTIONSEC=10
+HISTORYRETENTIONSEC=3600
+# StatsD UDP endpoint, e.g. "localhost:8125" (empty: disabled)
+STATSDADDRESS=""
 
 PROXYPORT=$(expr $PORT + 1)
 if lsof -Pi :$PROXYPORT -sTCP:LISTEN -t >/dev/null; then
@@ -63,21 +98,36 @@ CACHEPATHCOUNT=$mntpointcount
 echo Select Cloud Provider:
 echo  1: Amazon Cloud
 echo  2: Google Cloud
+echo  3: Both \(Amazon is the default\)
 echo Enter your choice:
 read cldprovider
 if [ $cldprovider -eq 1 ]
 then
 	CLDPROVIDER="aws"
+	CLDPROVIDERS="\"aws\""
 elif [ $cldprovider -eq 2 ]
 then
 	CLDPROVIDER="gcp"
+	CLDPROVIDERS="\"gcp\""
+elif [ $cldprovider -eq 3 ]
+then
+	CLDPROVIDER="aws"
+	CLDPROVIDERS="\"aws\", \"gcp\""
 else
-	echo "Error: '$cldprovider' is not a valid input, can be either 1 or 2"; exit 1
+	echo "Error: '$cldprovider' is not a valid input, can be either 1, 2, or 3"; exit 1
 fi
 # convert all timers to seconds
 let "STATSTIMESEC=$STATSTIMESEC*10**9"
 let "HTTPTIMEOUTSEC=$HTTPTIMEOUTSEC*10**9"
+let "STATSTIMEOUTSEC=$STATSTIMEOUTSEC*10**9"
 let "DONTEVICTIMESEC=$DONTEVICTIMESEC*10**9"
+let "ATIMEFLUSHTIMESEC=$ATIMEFLUSHTIMESEC*10**9"
+let "OBJECTTTLSEC=$OBJECTTTLSEC*10**9"
+let "EXPIRYTIMESEC=$EXPIRYTIMESEC*10**9"
+let "THROTTLELATENCYHIGHMS=$THROTTLELATENCYHIGHMS*10**6"
+let "THROTTLESLEEPMAXMS=$THROTTLESLEEPMAXMS*10**6"
+let "HISTORYRESOLUTIONSEC=$HISTORYRESOLUTIONSEC*10**9"
+let "HISTORYRETENTIONSEC=$HISTORYRETENTIONSEC*10**9"
 
 mkdir -p $CONFPATH
 
@@ -90,17 +140,33 @@ do
 	cat > $CONFFILE <<EOL
 	{
 		"logdir":			"${DIRPATH}${CURINSTANCE}${LOGDIR}",
+		"confdir":			"${CONFPATH}",
 		"loglevel": 			"${LOGLEVEL}",
 		"cloudprovider":		"${CLDPROVIDER}",
+		"cloudproviders":		[${CLDPROVIDERS}],
 		"stats_time":			${STATSTIMESEC},
+		"client_stats":			false,
 		"http_timeout":			${HTTPTIMEOUTSEC},
 		"listen": {
 			"proto": 		"${PROTO}",
 			"port":			"${PORT}"
 		},
+		"tls": {
+			"enabled":		${TLSENABLED},
+			"certificate":		"${TLSCERT}",
+			"key":			"${TLSKEY}",
+			"ca":			"${TLSCA}",
+			"mutual":		${TLSMUTUAL},
+			"skip_verify":		false
+		},
+		"auth": {
+			"enabled":		${AUTHENABLED},
+			"secret":		"${AUTHSECRET}"
+		},
 		"proxy": {
 			"url": 			"${PROXYURL}",
-			"passthru": 		${PASSTHRU}
+			"passthru": 		${PASSTHRU},
+			"stats_timeout":	${STATSTIMEOUTSEC}
 		},
 		"s3": {
 			"maxconcurrdownld":	${MAXCONCURRENTDOWNLOAD},
@@ -113,7 +179,36 @@ do
 			"errorthreshold":		${ERRORTHRESHOLD},
 			"fslowwatermark":		${FSLOWWATERMARK},
 			"fshighwatermark":		${FSHIGHWATERMARK},
-			"dont_evict_time":		${DONTEVICTIMESEC}
+			"dont_evict_time":		${DONTEVICTIMESEC},
+			"evict_policy":			"${EVICTPOLICY}",
+			"atime_flush_time":		${ATIMEFLUSHTIMESEC},
+			"object_ttl":			${OBJECTTTLSEC},
+			"expiry_time":			${EXPIRYTIMESEC},
+			"prefetch_workers":		${PREFETCHWORKERS}
+		},
+		"mirror": {
+			"copies":			${MIRRORCOPIES}
+		},
+		"ec": {
+			"enabled":			${ECENABLED},
+			"data_slices":			${ECDATASLICES},
+			"parity_slices":		${ECPARITYSLICES},
+			"objsize_limit":		${ECOBJSIZELIMIT}
+		},
+		"throttle": {
+			"disk_util_low":		${THROTTLEUTILLOW},
+			"disk_util_high":		${THROTTLEUTILHIGH},
+			"latency_high":			${THROTTLELATENCYHIGHMS},
+			"sleep_max":			${THROTTLESLEEPMAXMS},
+			"max_ops_per_sec":		${THROTTLEMAXOPS}
+		},
+		"stats_history": {
+			"resolution":			${HISTORYRESOLUTIONSEC},
+			"retention":			${HISTORYRETENTIONSEC}
+		},
+		"statsd": {
+			"address":			"${STATSDADDRESS}",
+			"prefix":			"dfc"
 		}
 	}
 EOL
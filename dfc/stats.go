@@ -7,13 +7,22 @@ package dfc
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/golang/glog"
 )
 
+// Stats registry: subsystems register their named counters, gauges and latency trackers
+// at init time (see the init functions here and elsewhere); updates are lock-free.
+// Every consumer - the logs, the JSON API (GET {"what": "stats"}) and /metrics - iterates
+// the registry. The proxy and the target have their own registries: proxyreg and storreg.
+
 type usedstats map[string]int
 
 // implemented by the stats runners
@@ -21,94 +30,210 @@ type statslogger interface {
 	log()
 }
 
-// implemented by the stats types: Proxystats and Storstats
+// implemented by the stats registry
 type statsif interface {
 	add(name string, val int64)
+	observe(name string, d time.Duration)
 }
 
-// TODO: use static map[string]int64
-type Proxystats struct {
-	Numget    int64 `json:"numget"`
-	Numput    int64 `json:"numput"`
-	Numpost   int64 `json:"numpost"`
-	Numdelete int64 `json:"numdelete"`
-	Numerr    int64 `json:"numerr"`
+// JSON: counters and gauges by name, with the dot-separated names nested
+// (e.g., "aws.numcoldget" => {"aws": {"numcoldget": ...}}), and derived values
+type Stats map[string]interface{}
+
+// metric kinds
+const (
+	statsCounter = iota
+	statsGauge
+	statsLatency
+)
+
+type metric struct {
+	kind     int
+	val      int64      // counter, gauge
+	hist     *histogram // latency
+	promname string     // see metrics.go
+	labels   string
 }
 
-// TODO: same
-type Storstats struct {
-	Proxystats
-	Numcoldget   int64 `json:"numcoldget"`
-	Bytesloaded  int64 `json:"bytesloaded"`
-	Bytesevicted int64 `json:"bytesevicted"`
-	Filesevicted int64 `json:"filesevicted"`
+// NOTE: registration is not synchronized and must precede updates, see init()
+type statsregistry struct {
+	metrics map[string]*metric
+	names   []string // sorted
+	unknown struct {
+		sync.Mutex
+		names map[string]bool
+	}
 }
 
 type statsrunner struct {
 	namedrunner
 	statslogger
 	chsts chan os.Signal
+	reg   *statsregistry
+	lock  sync.Mutex // protects the following
+	// over the previous interval, see latency.go
+	latency      map[string]*Latencystats
+	rates        map[string]float64
+	prevlat      map[string]*histogram
+	prevcounters map[string]int64
+	prevtime     time.Time
+	// as of the previous log
+	logged  map[string]int64
+	history *statshistory   // see history.go
+	statsd  *statsdexporter // ditto statsd.go
 }
 
 type proxystatsrunner struct {
 	statsrunner
-	stats     Proxystats
-	statscopy Proxystats
-	lock      *sync.Mutex
 }
 
 type storstatsrunner struct {
 	statsrunner
-	stats     Storstats
-	statscopy Storstats
-	used      usedstats
-	lock      *sync.Mutex
-}
-
-// TODO: use static map[string]int64
-func (s *Proxystats) add(name string, val int64) {
-	var v *int64
-	switch name {
-	case "numget":
-		v = &s.Numget
-	case "numput":
-		v = &s.Numput
-	case "numpost":
-		v = &s.Numpost
-	case "numdelete":
-		v = &s.Numdelete
-	case "numerr":
-		v = &s.Numerr
-	default:
-		assert(false, "Invalid stats name "+name)
-	}
-	*v += val
-}
-func (s *Storstats) add(name string, val int64) {
-	var v *int64
-	switch name {
-	case "numget":
-		v = &s.Numget
-	case "numput":
-		v = &s.Numput
-	case "numpost":
-		v = &s.Numpost
-	case "numdelete":
-		v = &s.Numdelete
-	case "numerr":
-		v = &s.Numerr
-	case "numcoldget":
-		v = &s.Numcoldget
-	case "bytesloaded":
-		v = &s.Bytesloaded
-	case "bytesevicted":
-		v = &s.Bytesevicted
-	case "filesevicted":
-		v = &s.Filesevicted
-	default:
-		assert(false, "Invalid stats name "+name)
-	}
-	*v += val
+	used usedstats
+}
+
+var (
+	proxyreg = newstatsregistry()
+	storreg  = newstatsregistry()
+)
+
+func init() {
+	for _, reg := range []*statsregistry{proxyreg, storreg} {
+		reg.counter("numget", "numput", "numpost", "numdelete", "numerr")
+	}
+	storreg.counter("numcoldget", "bytesloaded", "bytesserved", "bytesevicted", "filesevicted",
+		"numhit") // cloud objects served from the cache
+	for _, provider := range []string{amazoncloud, googlecloud} {
+		for _, name := range []string{"numlist", "numcoldget", "bytesloaded", "numerr"} {
+			storreg.register(provider+"."+name, statsCounter, "cloud_"+name, "provider="+strconv.Quote(provider))
+		}
+	}
+}
+
+//========================
+//
+// stats registry
+//
+//========================
+func newstatsregistry() *statsregistry {
+	return &statsregistry{metrics: make(map[string]*metric)}
+}
+
+func (reg *statsregistry) register(name string, kind int, promname, labels string) {
+	_, ok := reg.metrics[name]
+	assert(!ok, "Duplicate stats name "+name)
+	m := &metric{kind: kind, promname: promname, labels: labels}
+	if kind == statsLatency {
+		m.hist = newhistogram()
+	}
+	reg.metrics[name] = m
+	reg.names = append(reg.names, name)
+	sort.Strings(reg.names)
+}
+
+func (reg *statsregistry) counter(names ...string) {
+	for _, name := range names {
+		reg.register(name, statsCounter, name, "")
+	}
+}
+
+func (reg *statsregistry) gauge(names ...string) {
+	for _, name := range names {
+		reg.register(name, statsGauge, name, "")
+	}
+}
+
+// exported as dfc_latency_seconds{op="name"}
+func (reg *statsregistry) latency(names ...string) {
+	for _, name := range names {
+		reg.register(name, statsLatency, "latency_seconds", "op="+strconv.Quote(name))
+	}
+}
+
+func (reg *statsregistry) lookup(name string, kind int) *metric {
+	m, ok := reg.metrics[name]
+	if ok && (m.kind == kind || (kind == statsCounter && m.kind == statsGauge)) {
+		return m
+	}
+	// unknown name or wrong kind: log once
+	reg.unknown.Lock()
+	if reg.unknown.names == nil {
+		reg.unknown.names = make(map[string]bool)
+	}
+	if !reg.unknown.names[name] {
+		reg.unknown.names[name] = true
+		glog.Errorf("Invalid stats name %q (kind %d)", name, kind)
+	}
+	reg.unknown.Unlock()
+	return nil
+}
+
+// counters and gauges
+func (reg *statsregistry) add(name string, val int64) {
+	if m := reg.lookup(name, statsCounter); m != nil {
+		atomic.AddInt64(&m.val, val)
+	}
+}
+
+func (reg *statsregistry) set(name string, val int64) {
+	if m := reg.lookup(name, statsGauge); m != nil {
+		atomic.StoreInt64(&m.val, val)
+	}
+}
+
+func (reg *statsregistry) observe(name string, d time.Duration) {
+	if m := reg.lookup(name, statsLatency); m != nil {
+		m.hist.observe(d)
+	}
+}
+
+func (reg *statsregistry) addcloud(provider, name string, val int64) {
+	reg.add(provider+"."+name, val)
+}
+
+func (reg *statsregistry) get(name string) int64 {
+	if m, ok := reg.metrics[name]; ok {
+		return atomic.LoadInt64(&m.val)
+	}
+	return 0
+}
+
+// counters and gauges
+func (reg *statsregistry) snapshot() map[string]int64 {
+	out := make(map[string]int64, len(reg.names))
+	for _, name := range reg.names {
+		if m := reg.metrics[name]; m.kind != statsLatency {
+			out[name] = atomic.LoadInt64(&m.val)
+		}
+	}
+	return out
+}
+
+func (reg *statsregistry) latencies() (names []string) {
+	for _, name := range reg.names {
+		if reg.metrics[name].kind == statsLatency {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+func (reg *statsregistry) tojson() Stats {
+	out := make(Stats, len(reg.names))
+	for name, val := range reg.snapshot() {
+		split := strings.SplitN(name, ".", 2)
+		if len(split) == 1 {
+			out[name] = val
+			continue
+		}
+		sub, ok := out[split[0]].(Stats)
+		if !ok {
+			sub = make(Stats)
+			out[split[0]] = sub
+		}
+		sub[split[1]] = val
+	}
+	return out
 }
 
 //========================
@@ -119,15 +244,24 @@ func (s *Storstats) add(name string, val int64) {
 
 func (r *statsrunner) runcommon(logger statslogger) error {
 	r.chsts = make(chan os.Signal, 1)
+	history := newstatshistory(ctx.config.History.Resolution, ctx.config.History.Retention)
+	r.lock.Lock()
+	r.history = history
+	r.lock.Unlock()
 
 	glog.Infof("Starting %s", r.name)
 	ticker := time.NewTicker(ctx.config.StatsTime)
+	histticker := time.NewTicker(history.resolution)
 	for {
 		select {
 		case <-ticker.C:
 			logger.log()
+			r.pushstatsd()
+		case now := <-histticker.C:
+			history.add(now, r.reg.snapshot())
 		case <-r.chsts:
 			ticker.Stop()
+			histticker.Stop()
 			return nil
 		}
 	}
@@ -143,55 +277,127 @@ func (r *statsrunner) log() {
 	assert(false)
 }
 
-func (r *proxystatsrunner) run() error {
-	r.lock = &sync.Mutex{}
-	return r.runcommon(r)
+// the registry along with the latency and rates over the previous interval
+func (r *statsrunner) getstats() Stats {
+	stats := r.reg.tojson()
+	r.lock.Lock()
+	if len(r.latency) > 0 {
+		stats["latency"] = r.latency
+	}
+	if len(r.rates) > 0 {
+		stats["rates"] = r.rates
+	}
+	r.lock.Unlock()
+	return stats
+}
+
+// the stats time series, see history.go
+func (r *statsrunner) gethistory(names []string, since time.Time) *Statshistory {
+	r.lock.Lock()
+	history := r.history
+	r.lock.Unlock()
+	if history == nil { // not running yet
+		return &Statshistory{Samples: []Statssample{}}
+	}
+	return history.query(r.reg, names, since)
 }
 
-func (r *proxystatsrunner) syncstats(stats *Proxystats) {
+// updates the interval stats; returns the counters and whether any has changed since the previous call
+func (r *statsrunner) update(ratenames ...string) (counters map[string]int64, changed bool) {
+	counters = r.reg.snapshot()
+	lat, rates := r.interval(ratenames)
 	r.lock.Lock()
-	copyStruct(stats, &r.stats)
+	r.latency, r.rates = lat, rates
 	r.lock.Unlock()
+	r.loglatency(lat, rates)
+	for name, val := range counters {
+		if r.reg.metrics[name].kind == statsCounter && r.logged[name] != val {
+			changed = true
+		}
+	}
+	r.logged = counters
+	return
+}
+
+// "name,value,..." for the non-zero counters and gauges, sorted by name
+func (r *statsrunner) format(counters map[string]int64) string {
+	parts := make([]string, 0, 2*len(counters))
+	for _, name := range r.reg.names {
+		if val, ok := counters[name]; ok && val != 0 {
+			parts = append(parts, name, strconv.FormatInt(val, 10))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *proxystatsrunner) run() error {
+	return r.runcommon(r)
 }
 
 // statslogger interface impl
 func (r *proxystatsrunner) log() {
 	// nothing changed since the previous invocation
-	if r.stats.Numget == r.statscopy.Numget &&
-		r.stats.Numpost == r.statscopy.Numpost &&
-		r.stats.Numdelete == r.statscopy.Numdelete {
+	counters, changed := r.update("numget", "numput")
+	if !changed {
 		return
 	}
-	s := fmt.Sprintf("%s: %+v", r.name, r.stats)
-	r.syncstats(&r.statscopy)
-	glog.Infoln(s)
+	glog.Infof("%s: %s", r.name, r.format(counters))
 }
 
 func (r *storstatsrunner) run() error {
-	r.lock = &sync.Mutex{}
 	return r.runcommon(r)
 }
 
-func (r *storstatsrunner) syncstats(stats *Storstats) {
+// the registry along with the derived stats
+func (r *storstatsrunner) getstats() Stats {
+	stats := r.statsrunner.getstats()
+	stats["evictpolicy"] = evictor.name()
+	if quotas := quotausage(); len(quotas) > 0 {
+		stats["quotas"] = quotas
+	}
+	var hitratio float64
+	if n := r.reg.get("numhit") + r.reg.get("numcoldget"); n > 0 {
+		hitratio = float64(r.reg.get("numhit")) * 100 / float64(n)
+	}
+	stats["hitratio"] = hitratio // percent of the cloud GETs served from the cache
+	return stats
+}
+
+// a copy of the mountpath usage, percent
+func (r *storstatsrunner) getused() usedstats {
 	r.lock.Lock()
-	copyStruct(stats, &r.stats)
-	r.lock.Unlock()
+	defer r.lock.Unlock()
+	used := make(usedstats, len(r.used))
+	for mpath, u := range r.used {
+		used[mpath] = u
+	}
+	return used
 }
 
 func (r *storstatsrunner) log() {
+	// TTL expiry: objects expire on idle targets too
+	if expirydue() {
+		if err := startexpiry(); err != nil && glog.V(3) {
+			glog.Infoln(err)
+		}
+	}
 	// nothing changed since the previous invocation
-	if r.stats.Numget == r.statscopy.Numget &&
-		r.stats.Bytesloaded == r.statscopy.Bytesloaded &&
-		r.stats.Bytesevicted == r.statscopy.Bytesevicted {
+	counters, changed := r.update("numget", "numcoldget", "bytesloaded", "bytesserved")
+	if !changed {
 		return
 	}
 	// 1. format and log Get stats
-	mbytesloaded := float64(r.stats.Bytesloaded) / 1000 / 1000
-	mbytesevicted := float64(r.stats.Bytesevicted) / 1000 / 1000
+	mbytesloaded := float64(counters["bytesloaded"]) / 1000 / 1000
+	mbytesevicted := float64(counters["bytesevicted"]) / 1000 / 1000
 	s := fmt.Sprintf("%s: numget,%d,numcoldget,%d,mbytesloaded,%.2f,mbytesevicted,%.2f,filesevicted,%d,numerr,%d",
-		r.name, r.stats.Numget, r.stats.Numcoldget,
-		mbytesloaded, mbytesevicted, r.stats.Filesevicted, r.stats.Numerr)
+		r.name, counters["numget"], counters["numcoldget"],
+		mbytesloaded, mbytesevicted, counters["filesevicted"], counters["numerr"])
 	glog.Infoln(s)
+	glog.Infof("%s all: %s", r.name, r.format(counters))
+	if n := counters["numhit"] + counters["numcoldget"]; n > 0 {
+		glog.Infof("%s %s: numhit,%d,nummiss,%d,hitratio,%.2f%%", r.name, evictor.name(),
+			counters["numhit"], counters["numcoldget"], float64(counters["numhit"])*100/float64(n))
+	}
 
 	// 2. assign usage %%
 	var runlru bool
@@ -200,7 +406,9 @@ func (r *storstatsrunner) log() {
 		uu, ok := fsmap[mountpath.Fsid]
 		if ok {
 			// the same filesystem: usage cannot be different..
+			r.lock.Lock()
 			r.used[mountpath.Path] = uu
+			r.lock.Unlock()
 			continue
 		}
 		statfs := syscall.Statfs_t{}
@@ -212,15 +420,21 @@ func (r *storstatsrunner) log() {
 		if u >= uint64(ctx.config.Cache.FSHighWaterMark) {
 			runlru = true
 		}
+		r.lock.Lock()
 		r.used[mountpath.Path], fsmap[mountpath.Fsid] = int(u), int(u)
+		r.lock.Unlock()
 	}
 
-	// 3. format and log usage %%
-	s = fmt.Sprintf("%s used: %+v", r.name, r.used)
+	// 3. format and log usage %% and pinned space
+	files, bytes := pinnedsize()
+	r.reg.set("filespinned", files)
+	r.reg.set("bytespinned", bytes)
+	s = fmt.Sprintf("%s used: %+v, pinned: %d files, %.2f MB", r.name, r.used, files, float64(bytes)/1000/1000)
 	glog.Infoln(s)
-	r.syncstats(&r.statscopy)
 	// 4. LRU
 	if runlru {
-		go all_LRU()
+		if err := startlru(&LRUMsg{}); err != nil && glog.V(3) {
+			glog.Infoln(err)
+		}
 	}
 }
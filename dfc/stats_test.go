@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Stats registry: counters, gauges, invalid names, and the JSON representation.
+//
+// Example run:
+// 	go test -v -run=statsregistry
+//
+package dfc
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func Test_statsregistry(t *testing.T) {
+	reg := newstatsregistry()
+	reg.counter("numget", "aws.numcoldget")
+	reg.gauge("filespinned")
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				reg.add("numget", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	reg.addcloud("aws", "numcoldget", 2)
+	reg.set("filespinned", 5)
+	reg.set("numget", 1)      // not a gauge: ignored
+	reg.add("nosuchstats", 1) // ignored
+
+	if reg.get("numget") != 8000 || reg.get("filespinned") != 5 {
+		t.Errorf("Unexpected values %v", reg.snapshot())
+	}
+	jsbytes, err := json.Marshal(reg.tojson())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"aws":{"numcoldget":2},"filespinned":5,"numget":8000}`; string(jsbytes) != expected {
+		t.Errorf("Expected %s, got %s", expected, string(jsbytes))
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// StatsD exporter: when StatsD.Address is configured, the stats runner pushes all counters
+// (as the deltas since the previous push) and gauges to the StatsD UDP endpoint every StatsTime,
+// named <prefix>.<daemon ID>.<stats name>, e.g. dfc.12345_8081.numget
+
+const (
+	statsdprefix    = "dfc"
+	statsdmaxpacket = 1432 // to fit in a single Ethernet frame
+)
+
+type statsdexporter struct {
+	conn    net.Conn
+	prefix  string
+	prev    map[string]int64 // counters as of the previous push
+	failing bool             // to log the errors only once
+}
+
+func newstatsd(address, prefix string) (*statsdexporter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdexporter{conn: conn, prefix: prefix, prev: make(map[string]int64)}, nil
+}
+
+func (s *statsdexporter) push(reg *statsregistry) error {
+	var (
+		buf      bytes.Buffer
+		err      error
+		counters = reg.snapshot()
+	)
+	send := func() {
+		if buf.Len() > 0 {
+			if _, e := s.conn.Write(buf.Bytes()); e != nil && err == nil {
+				err = e
+			}
+			buf.Reset()
+		}
+	}
+	for _, name := range reg.names {
+		var line string
+		switch reg.metrics[name].kind {
+		case statsCounter:
+			delta := counters[name] - s.prev[name]
+			if delta == 0 {
+				continue
+			}
+			line = fmt.Sprintf("%s.%s:%d|c", s.prefix, name, delta)
+		case statsGauge:
+			line = fmt.Sprintf("%s.%s:%d|g", s.prefix, name, counters[name])
+		default:
+			continue
+		}
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdmaxpacket {
+			send()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	send()
+	s.prev = counters
+	return err
+}
+
+// called by the stats runner every StatsTime
+func (r *statsrunner) pushstatsd() {
+	config := &ctx.config.StatsD
+	if config.Address == "" {
+		return
+	}
+	if r.statsd == nil {
+		id := daemonid()
+		if id == "" { // not initialized yet
+			return
+		}
+		prefix := config.Prefix
+		if prefix == "" {
+			prefix = statsdprefix
+		}
+		s, err := newstatsd(config.Address, prefix+"."+strings.NewReplacer(":", "_", ".", "_").Replace(id))
+		if err != nil {
+			glog.Errorf("Failed to connect to StatsD at %s, err: %v", config.Address, err)
+			return
+		}
+		r.statsd = s
+	}
+	err := r.statsd.push(r.reg)
+	if err != nil && !r.statsd.failing {
+		glog.Errorf("Failed to push stats to StatsD at %s, err: %v", config.Address, err)
+	}
+	r.statsd.failing = err != nil
+}
+
+func daemonid() string {
+	if ctx.rg == nil {
+		return ""
+	}
+	if p, ok := ctx.rg.runmap[xproxy].(*proxyrunner); ok && p.si != nil {
+		return p.si.DaemonID
+	}
+	if t, ok := ctx.rg.runmap[xtarget].(*targetrunner); ok && t.si != nil {
+		return t.si.DaemonID
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// StatsD exporter: counter deltas and gauges pushed to a local UDP listener.
+//
+// Example run:
+// 	go test -v -run=statsd
+//
+package dfc
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_statsd(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	receive := func() []string {
+		buf := make([]byte, 64*1024)
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(string(buf[:n]), "\n")
+		sort.Strings(lines)
+		return lines
+	}
+
+	reg := newstatsregistry()
+	reg.counter("numget", "numerr")
+	reg.gauge("bytespinned")
+	reg.latency(OpGetHit)
+	s, err := newstatsd(listener.LocalAddr().String(), "dfc.1234_8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.add("numget", 5)
+	reg.set("bytespinned", 100)
+	reg.observe(OpGetHit, time.Millisecond)
+	if err = s.push(reg); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"dfc.1234_8081.bytespinned:100|g", "dfc.1234_8081.numget:5|c"}
+	if lines := receive(); strings.Join(lines, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+
+	// deltas
+	reg.add("numget", 2)
+	reg.add("numerr", 1)
+	if err = s.push(reg); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"dfc.1234_8081.bytespinned:100|g", "dfc.1234_8081.numerr:1|c", "dfc.1234_8081.numget:2|c"}
+	if lines := receive(); strings.Join(lines, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+
+	// large registries are split into multiple packets
+	big := newstatsregistry()
+	for i := 0; i < 200; i++ {
+		name := "counter" + strings.Repeat("x", i%10) + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		big.counter(name)
+		big.add(name, 1)
+	}
+	if err = s.push(big); err != nil {
+		t.Fatal(err)
+	}
+	var total int
+	for total < 200 {
+		lines := receive()
+		if size := len(strings.Join(lines, "\n")); size > statsdmaxpacket {
+			t.Errorf("Packet size %d exceeds %d", size, statsdmaxpacket)
+		}
+		total += len(lines)
+	}
+	if total != 200 {
+		t.Errorf("Expected 200 lines, got %d", total)
+	}
+}
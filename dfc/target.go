@@ -12,7 +12,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 )
@@ -37,8 +40,8 @@ type cinterface interface {
 //===========================================================================
 type targetrunner struct {
 	httprunner
-	cloudif cinterface // multi-cloud vendor support
-	smap    *Smap
+	cloudifs map[string]cinterface // multi-cloud vendor support, one per configured provider
+	smap     *Smap
 }
 
 // start target runner
@@ -54,7 +57,7 @@ func (t *targetrunner) run() error {
 	}
 	// local mp-s have precedence over cachePath
 	var err error
-	ctx.mountpaths = make(map[string]mountPath, 4)
+	ctx.mountpaths = make(map[string]*mountPath, 4)
 	if err = parseProcMounts(procMountsPath); err != nil {
 		glog.Errorf("Failed to parse %s, err: %v", procMountsPath, err)
 		return err
@@ -77,15 +80,19 @@ func (t *targetrunner) run() error {
 
 	// init per-mp usage stats
 	initusedstats()
+	getatimerunner().load()
+	go getatimerunner().seedall()
 
-	// cloud provider
-	assert(ctx.config.CloudProvider == amazoncloud || ctx.config.CloudProvider == googlecloud)
-	if ctx.config.CloudProvider == amazoncloud {
-		// TODO: AWS initialization (sessions)
-		t.cloudif = &awsif{}
-
-	} else {
-		t.cloudif = &gcpif{}
+	evictor, _ = newevictpolicy(ctx.config.Cache.EvictPolicy) // validated in initconfigparam
+	// cloud providers
+	t.cloudifs = make(map[string]cinterface, len(ctx.config.CloudProviders))
+	for _, provider := range ctx.config.CloudProviders {
+		if provider == amazoncloud {
+			// TODO: AWS initialization (sessions)
+			t.cloudifs[provider] = &awsif{}
+		} else {
+			t.cloudifs[provider] = &gcpif{}
+		}
 	}
 	//
 	// REST API: register storage target's handler(s) and start listening
@@ -93,6 +100,8 @@ func (t *targetrunner) run() error {
 	t.httprunner.registerhdlr("/"+Rversion+"/"+Rfiles+"/", t.filehdlr)
 	t.httprunner.registerhdlr("/"+Rversion+"/"+Rdaemon, t.daemonhdlr)
 	t.httprunner.registerhdlr("/"+Rversion+"/"+Rdaemon+"/", t.daemonhdlr) // FIXME
+	t.httprunner.registerhdlr("/"+Rversion+"/"+Rslices+"/", t.slicehdlr)
+	t.httprunner.registerhdlr("/"+Rmetrics, t.metricshdlr)
 	t.httprunner.registerhdlr("/", invalhdlr)
 	glog.Infof("Storage target is ready, ID=%s", t.si.DaemonID)
 	return t.httprunner.run()
@@ -113,8 +122,19 @@ func (t *targetrunner) register() error {
 		return err
 	}
 	url := ctx.config.Proxy.URL + "/" + Rversion + "/" + Rcluster
-	_, err = t.call(url, http.MethodPost, jsbytes)
-	return err
+	outjson, err := t.call(url, http.MethodPost, jsbytes)
+	if err != nil || len(outjson) == 0 {
+		return err
+	}
+	// the proxy responds with the current local buckets
+	bmd := newbucketmd()
+	if err = json.Unmarshal(outjson, bmd); err != nil {
+		glog.Errorf("Failed to json-unmarshal local buckets, err: %v [%s]", err, string(outjson))
+		return err
+	}
+	ctx.bmd.update(bmd)
+	pinned.set(ctx.bmd.pinlist())
+	return nil
 }
 
 func (t *targetrunner) unregister() error {
@@ -137,6 +157,8 @@ func (t *targetrunner) filehdlr(w http.ResponseWriter, r *http.Request) {
 		t.httpfilget(w, r)
 	case http.MethodPut:
 		t.httpfilput(w, r)
+	case http.MethodDelete:
+		t.httpfildelete(w, r)
 	default:
 		invalhdlr(w, r)
 	}
@@ -151,36 +173,101 @@ func (t *targetrunner) httpfilget(w http.ResponseWriter, r *http.Request) {
 	if apitems = t.checkRestAPI(w, r, apitems, 1, Rversion, Rfiles); apitems == nil {
 		return
 	}
-	bucket, objname := apitems[0], ""
+	started := time.Now()
+	nsprovider, bucket := parsebucket(apitems[0])
+	objname := ""
 	if len(apitems) > 1 {
 		objname = apitems[1]
 	}
+	cbucket := cachebucket(nsprovider, bucket) // NOTE: local copies are placed, cached and accounted by this name
 	t.statsif.add("numget", 1)
+	bstats(r, cbucket, "numget", 1)
 	//
 	// list the bucket and return
 	//
+	islocal, props := ctx.bmd.islocal(bucket), ctx.bmd.getprops(bucket)
+	cloudif, provider := t.getcloudif(nsprovider, &props)
+	if !islocal && cloudif == nil {
+		s := fmt.Sprintf("Bucket %s: cloud provider %q is not configured (%v)",
+			bucket, provider, ctx.config.CloudProviders)
+		t.statsif.add("numerr", 1)
+		bstats(r, cbucket, "numerr", 1)
+		invalmsghdlr(w, r, s)
+		return
+	}
 	if len(objname) == 0 {
-		getcloudif().listbucket(w, bucket)
+		if islocal {
+			t.listlocalbucket(w, bucket)
+		} else {
+			getstorstats().addcloud(provider, "numlist", 1)
+			cloudif.listbucket(w, bucket)
+		}
 		return
 	}
 	//
 	// get from the bucket
 	//
-	fqn := t.fqn(bucket, objname)
-	var file *os.File
-	_, err := os.Stat(fqn)
-	if os.IsNotExist(err) {
+	var (
+		file    *os.File
+		err     error
+		coldget bool
+	)
+	fqn, mountpath := mirrorlookup(cbucket, objname)
+	if fqn != "" {
+		if fqn = t.validcached(cbucket, objname, fqn, &props, islocal); fqn != "" {
+			mountpath = fqn2mountpath(fqn)
+		}
+	}
+	if fqn == "" && islocal && ctx.config.EC.Enabled { // cloud buckets are not erasure-coded
+		fqn = t.fqn(bucket, objname)
+		if err = t.ecrestore(bucket, objname, fqn); err != nil {
+			glog.Infof("Failed to restore %s/%s from slices, err: %v", bucket, objname, err)
+			fqn = ""
+		} else {
+			mountpath = fqn2mountpath(fqn)
+		}
+	}
+	if fqn == "" && islocal {
+		s := fmt.Sprintf("Object %s/%s does not exist", bucket, objname)
+		t.statsif.add("numerr", 1)
+		bstats(r, cbucket, "numerr", 1)
+		glog.Errorln(errmsgRestApi(s, r))
+		http.Error(w, s, http.StatusNotFound)
+		return
+	}
+	if fqn == "" {
+		fqn, coldget = t.fqn(cbucket, objname), true
 		t.statsif.add("numcoldget", 1)
+		bstats(r, cbucket, "numcoldget", 1)
+		getstorstats().addcloud(provider, "numcoldget", 1)
 		glog.Infof("Bucket %s key %s fqn %q is not cached", bucket, objname, fqn)
-		// TODO: do getcloudif().getobj() and write http response in parallel
-		if file, err = getcloudif().getobj(w, fqn, bucket, objname); err != nil {
+		if overquota(cbucket) { // make room before the fill
+			if err = quotaevict(cbucket, ""); err != nil {
+				t.statsif.add("numerr", 1)
+				bstats(r, cbucket, "numerr", 1)
+				glog.Errorln(errmsgRestApi(err.Error(), r))
+				http.Error(w, err.Error(), http.StatusInsufficientStorage)
+				return
+			}
+		}
+		// TODO: do cloudif.getobj() and write http response in parallel
+		fetchstarted := time.Now()
+		if file, err = cloudif.getobj(w, fqn, bucket, objname); err != nil {
+			getstorstats().addcloud(provider, "numerr", 1)
+			bstats(r, cbucket, "numerr", 1)
 			return
 		}
+		t.statsif.observe(OpCloudFetch, time.Since(fetchstarted))
 		file.Seek(0, 0) // NOTE: needed?
+		t.coldloaded(cbucket, objname, fqn, &props)
 	} else {
+		atomic.AddInt64(&mountpath.inflight, 1)
+		defer atomic.AddInt64(&mountpath.inflight, -1)
 		if file, err = os.Open(fqn); err != nil {
 			s := fmt.Sprintf("Failed to open local file %q, err: %v", fqn, err)
 			t.statsif.add("numerr", 1)
+			bstats(r, cbucket, "numerr", 1)
+			checksetmounterror(fqn)
 			invalmsghdlr(w, r, s)
 			return
 		}
@@ -189,17 +276,58 @@ func (t *targetrunner) httpfilget(w http.ResponseWriter, r *http.Request) {
 	// NOTE: the following copyBuffer() call is equaivalent to:
 	// 	rt, _ := w.(io.ReaderFrom)
 	// 	written, err := rt.ReadFrom(file) ==> sendfile path
+	ttfb := time.Since(started) // NOTE: approximately, as of the start of the copy
+	t.statsif.observe(OpTTFB, ttfb)
 	written, err := copyBuffer(w, file)
 	if err != nil {
 		glog.Errorf("Failed to copy %q to http, err: %v", fqn, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		t.statsif.add("numerr", 1)
-	} else if glog.V(3) {
-		glog.Infof("Copied %q to http(%.2f MB)", fqn, float64(written)/1000/1000)
+		bstats(r, cbucket, "numerr", 1)
+	} else {
+		latency := time.Since(started)
+		if coldget {
+			t.statsif.observe(OpColdGet, latency)
+		} else {
+			t.statsif.observe(OpGetHit, latency)
+			// local reads only: neither the cloud nor the client's network is a reason to throttle
+			throttl.observe(ttfb)
+		}
+		t.statsif.add("bytesserved", written)
+		bstats(r, cbucket, "bytesserved", written)
+		if !islocal {
+			if !coldget {
+				t.statsif.add("numhit", 1)
+			}
+			atimetouch(fqn, written)
+			evictor.access(cbucket+"/"+objname, written, !coldget)
+			if coldget && overquota(cbucket) {
+				go func() {
+					if err := quotaevict(cbucket, fqn); err != nil {
+						glog.Errorln(err)
+					}
+				}()
+			}
+		}
+		if glog.V(3) {
+			glog.Infof("Copied %q to http(%.2f MB)", fqn, float64(written)/1000/1000)
+		}
 	}
 	glog.Flush()
 }
 
+// post-processing of a freshly downloaded cloud object: checksum and local copies, as configured
+func (t *targetrunner) coldloaded(bucket, objname, fqn string, props *BucketProps) {
+	if props.Checksum == ChecksumXXHash {
+		if err := setxxhash(fqn); err != nil {
+			glog.Errorf("Failed to checksum %q, err: %v", fqn, err)
+		}
+	}
+	if mirrorcopies(bucket) > 1 {
+		gomirrorobj(bucket, objname, fqn)
+	}
+}
+
 // '{CopyMsg}' "/"+Rversion+"/"+Rfiles+"/"+bucket+"/"+objname
 func (t *targetrunner) httpfilput(w http.ResponseWriter, r *http.Request) {
 	var s, fqn string
@@ -208,7 +336,12 @@ func (t *targetrunner) httpfilput(w http.ResponseWriter, r *http.Request) {
 	if apitems = t.checkRestAPI(w, r, apitems, 2, Rversion, Rfiles); apitems == nil {
 		return
 	}
-	bucket, objname := apitems[0], apitems[1]
+	nsprovider, bucket := parsebucket(apitems[0])
+	objname := apitems[1]
+	if ctx.bmd.islocal(bucket) {
+		t.putlocal(w, r, bucket, objname)
+		return
+	}
 	var msg CopyMsg
 	if t.readJson(w, r, &msg) != nil {
 		return
@@ -218,7 +351,7 @@ func (t *targetrunner) httpfilput(w http.ResponseWriter, r *http.Request) {
 			t.si.DaemonID, msg.FromID, msg.ToID)
 		goto merr
 	}
-	fqn = t.fqn(bucket, objname)
+	fqn = t.fqn(cachebucket(nsprovider, bucket), objname)
 	_, err = os.Stat(fqn)
 	if t.si.DaemonID == msg.FromID {
 		//
@@ -288,12 +421,211 @@ merr:
 	invalmsghdlr(w, r, s)
 }
 
+// provider: URL namespace, if specified, then bucket properties, then the configured default
+func (t *targetrunner) getcloudif(nsprovider string, props *BucketProps) (cinterface, string) {
+	provider := nsprovider
+	if provider == "" {
+		provider = props.CloudProvider
+	}
+	if provider == "" {
+		provider = ctx.config.CloudProvider
+	}
+	return t.cloudifs[provider], provider
+}
+
+// applies bucket properties to a cached object: TTL (cloud buckets only, see expiry.go) and checksum;
+// removes all local copies of the object if it's stale; if the checksum doesn't match, validates
+// the remaining copies and removes only the corrupted ones; returns the fqn of a valid copy (empty if none)
+func (t *targetrunner) validcached(bucket, objname, fqn string, props *BucketProps, islocal bool) string {
+	r := getatimerunner()
+	remove := func(f string) {
+		os.Remove(f)
+		if r != nil {
+			r.remove(f)
+		}
+	}
+	if ttl := objttl(props, islocal); ttl > 0 {
+		if finfo, err := os.Stat(fqn); err == nil && time.Since(finfo.ModTime()) > ttl {
+			glog.Infof("%s/%s is stale (TTL %v)", bucket, objname, ttl)
+			t.statsif.add("filesexpired", 1)
+			t.statsif.add("bytesexpired", finfo.Size())
+			for _, f := range mirrorfqns(bucket, objname) { // the copies expire together
+				remove(f)
+			}
+			evictor.removed(bucket + "/" + objname)
+			return ""
+		}
+	}
+	if props.Checksum != ChecksumXXHash {
+		return fqn
+	}
+	fqns := []string{fqn}
+	for _, f := range mirrorfqns(bucket, objname) {
+		if f != fqn {
+			fqns = append(fqns, f)
+		}
+	}
+	for i, f := range fqns {
+		if i > 0 {
+			if _, err := os.Stat(f); err != nil {
+				continue
+			}
+		}
+		if ok, err := checkxxhash(f); !ok {
+			glog.Errorf("Checksum mismatch %q, err: %v", f, err)
+			t.statsif.add("numerr", 1)
+			remove(f)
+			continue
+		}
+		if i > 0 {
+			gomirrorobj(bucket, objname, f) // restore the removed copies
+		}
+		return f
+	}
+	return ""
+}
+
+//===========================
 //
-// Cloud bucket + object => (local hashed path, fully qualified filename)
+// local buckets
 //
+//===========================
+
+// PUT /v1/files/bucket/objname: stores the object, its local copies and, if configured, slices
+func (t *targetrunner) putlocal(w http.ResponseWriter, r *http.Request, bucket, objname string) {
+	props := ctx.bmd.getprops(bucket)
+	if props.ReadOnly {
+		s := errmsgRestApi("Bucket "+bucket+" is read-only", r)
+		t.statsif.add("numerr", 1)
+		glog.Errorln(s)
+		http.Error(w, s, http.StatusForbidden)
+		return
+	}
+	fqn := t.fqn(bucket, objname)
+	tmpfqn := filepath.Dir(fqn) + "/." + filepath.Base(fqn) + ".tmp"
+	if err := ecsave(tmpfqn, fqn, r.Body); err != nil {
+		s := fmt.Sprintf("Failed to store %s/%s, err: %v", bucket, objname, err)
+		t.statsif.add("numerr", 1)
+		checksetmounterror(fqn)
+		glog.Errorln(s)
+		http.Error(w, s, http.StatusInternalServerError)
+		return
+	}
+	if props.Checksum == ChecksumXXHash {
+		if err := setxxhash(fqn); err != nil {
+			glog.Errorf("Failed to checksum %q, err: %v", fqn, err)
+		}
+	}
+	// stale copies of the previous version, if any, are removed first
+	for _, f := range mirrorfqns(bucket, objname) {
+		if f != fqn {
+			os.Remove(f)
+		}
+	}
+	if mirrorcopies(bucket) > 1 {
+		mirrorobj(bucket, objname, fqn)
+	}
+	if ctx.config.EC.Enabled {
+		// the object is stored but not protected: not a success
+		if err := t.ecencode(bucket, objname, fqn); err != nil {
+			s := fmt.Sprintf("Stored %s/%s but failed to erasure-code it, err: %v", bucket, objname, err)
+			t.statsif.add("numerr", 1)
+			glog.Errorln(s)
+			http.Error(w, s, http.StatusInternalServerError)
+			return
+		}
+	}
+	t.statsif.add("numput", 1)
+	if glog.V(3) {
+		glog.Infof("PUT %s/%s => %q", bucket, objname, fqn)
+	}
+}
+
+// DELETE /v1/files/bucket/objname
+func (t *targetrunner) httpfildelete(w http.ResponseWriter, r *http.Request) {
+	apitems := t.restApiItems(r.URL.Path, 5)
+	if apitems = t.checkRestAPI(w, r, apitems, 2, Rversion, Rfiles); apitems == nil {
+		return
+	}
+	_, bucket := parsebucket(apitems[0])
+	objname := apitems[1]
+	if !ctx.bmd.islocal(bucket) {
+		s := fmt.Sprintf("DELETE is supported only for local buckets (%s is not)", bucket)
+		t.statsif.add("numerr", 1)
+		invalmsghdlr(w, r, s)
+		return
+	}
+	if ctx.bmd.getprops(bucket).ReadOnly {
+		s := errmsgRestApi("Bucket "+bucket+" is read-only", r)
+		t.statsif.add("numerr", 1)
+		glog.Errorln(s)
+		http.Error(w, s, http.StatusForbidden)
+		return
+	}
+	var found bool
+	for _, fqn := range mirrorfqns(bucket, objname) {
+		if err := os.Remove(fqn); err == nil {
+			found = true
+		}
+	}
+	if ctx.config.EC.Enabled {
+		t.ecdelete(bucket, objname)
+	}
+	if !found {
+		s := fmt.Sprintf("Object %s/%s does not exist", bucket, objname)
+		http.Error(w, s, http.StatusNotFound)
+		return
+	}
+	t.statsif.add("numdelete", 1)
+}
+
+// lists the objects of a local bucket stored on this target
+func (t *targetrunner) listlocalbucket(w http.ResponseWriter, bucket string) {
+	names := make(map[string]bool, 64)
+	for _, mountpath := range ctx.mountpaths {
+		dir := mountpath.Path + "/" + bucket
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		walk := func(fqn string, osfi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if strings.HasPrefix(osfi.Name(), ".") || osfi.Mode().IsDir() {
+				return nil
+			}
+			if objname, err := filepath.Rel(dir, fqn); err == nil {
+				names[objname] = true // NOTE: dedup local copies
+			}
+			return nil
+		}
+		if err := filepath.Walk(dir, walk); err != nil {
+			glog.Errorf("Failed to traverse %q, err: %v", dir, err)
+		}
+	}
+	for objname := range names {
+		fmt.Fprintln(w, objname)
+	}
+}
+
+// removes the objects (and slices) of the destroyed local buckets
+func destroylocalbuckets(buckets []string) {
+	for _, bucket := range buckets {
+		for _, mountpath := range ctx.mountpaths {
+			for _, dir := range []string{"", ecSliceDir, ecSliceMetaDir, ecMetaDir} {
+				if err := os.RemoveAll(mountpath.Path + dir + "/" + bucket); err != nil {
+					glog.Errorf("Failed to remove local bucket %s at %q, err: %v", bucket, mountpath.Path, err)
+				}
+			}
+		}
+		glog.Infof("Destroyed local bucket %s", bucket)
+	}
+}
+
+// Bucket (see cachebucket) + object => (local hashed path, fully qualified filename)
 func (t *targetrunner) fqn(bucket, objname string) string {
 	mpath := hrwMpath(bucket + "/" + objname)
-	assert(len(mpath) > 0) // FIXME; see mountpath.enabled
+	assert(len(mpath) > 0) // FIXME; see mountPath.isenabled
 	return mpath + "/" + bucket + "/" + objname
 }
 
@@ -339,6 +671,25 @@ func (t *targetrunner) httpdaeput(w http.ResponseWriter, r *http.Request) {
 			}
 			glog.Flush()
 			t.smap = smap
+			if ctx.config.EC.Enabled {
+				go t.ecrebuild()
+			}
+		}
+		return
+	}
+
+	// PUT '{bucketmd}' /v1/daemon/syncbmd => target(s)
+	if len(apitems) > 0 && apitems[0] == Rsyncbmd {
+		bmd := newbucketmd()
+		if t.readJson(w, r, bmd) != nil {
+			return
+		}
+		if removed, ok := ctx.bmd.update(bmd); ok {
+			glog.Infof("syncbmd: new version %d, local buckets %v, %d pins", bmd.Version, bmd.LBmap, len(bmd.Pins))
+			pinned.set(ctx.bmd.pinlist())
+			if len(removed) > 0 {
+				go destroylocalbuckets(removed)
+			}
 		}
 		return
 	}
@@ -350,12 +701,49 @@ func (t *targetrunner) httpdaeput(w http.ResponseWriter, r *http.Request) {
 	switch msg.Action {
 	case ActionShutdown:
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	case ActionLRU:
+		t.lruaction(w, r, &msg)
+	case ActionEvict:
+		t.evictaction(w, r, &msg)
+	case ActionPrefetch:
+		t.prefetchaction(w, r, &msg)
+	case ActionAbortXact:
+		if err := xactreg.abort(msg.Param1); err != nil {
+			invalmsghdlr(w, r, err.Error())
+		}
 	default:
 		s := fmt.Sprintf("Unexpected ActionMsg <- JSON [%v]", msg)
 		invalmsghdlr(w, r, s)
 	}
 }
 
+// PUT '{"action": "lru", "param1": "start"|"pause"|"resume"|"abort", "value": {LRUMsg}}' /v1/daemon
+func (t *targetrunner) lruaction(w http.ResponseWriter, r *http.Request, msg *ActionMsg) {
+	var err error
+	switch msg.Param1 {
+	case "", LRUStart:
+		var lrumsg LRUMsg
+		if len(msg.Value) > 0 {
+			if err = json.Unmarshal(msg.Value, &lrumsg); err != nil {
+				break
+			}
+		}
+		if lrumsg.Lwm > 100 || lrumsg.Hwm > 100 || (lrumsg.Lwm > 0 && lrumsg.Hwm > 0 && lrumsg.Lwm > lrumsg.Hwm) {
+			err = fmt.Errorf("Invalid watermarks: lwm %d, hwm %d", lrumsg.Lwm, lrumsg.Hwm)
+			break
+		}
+		err = startlru(&lrumsg)
+	case LRUPause, LRUResume, LRUAbort:
+		err = curlru.control(msg.Param1)
+	default:
+		err = fmt.Errorf("Unexpected LRU action %q", msg.Param1)
+	}
+	if err != nil {
+		s := fmt.Sprintf("Failed to %s %s, err: %v", msg.Action, msg.Param1, err)
+		invalmsghdlr(w, r, s)
+	}
+}
+
 func (t *targetrunner) httpdaeget(w http.ResponseWriter, r *http.Request) {
 	apitems := t.restApiItems(r.URL.Path, 5)
 	if apitems = t.checkRestAPI(w, r, apitems, 0, Rversion, Rdaemon); apitems == nil {
@@ -373,9 +761,31 @@ func (t *targetrunner) httpdaeget(w http.ResponseWriter, r *http.Request) {
 	case GetConfig:
 		jsbytes, err = json.Marshal(t.si)
 		assert(err == nil, err)
+	case GetPins:
+		jsbytes = pinned.marshal()
+	case GetLRU:
+		jsbytes, err = json.Marshal(curlru.getstatus())
+		assert(err == nil, err)
+	case GetXactions:
+		jsbytes, err = json.Marshal(xactreg.list())
+		assert(err == nil, err)
+	case GetCapacity:
+		jsbytes, err = json.Marshal(getcapacity())
+		assert(err == nil, err)
+	case GetHistory:
+		names, since, err := parsehistorymsg(&msg)
+		if err != nil {
+			invalmsghdlr(w, r, err.Error())
+			return
+		}
+		jsbytes, err = json.Marshal(getstorstatsrunner().gethistory(names, since))
+		assert(err == nil, err)
 	case GetStats:
-		var stats Storstats
-		getstorstatsrunner().syncstats(&stats)
+		stats := getstorstatsrunner().getstats()
+		stats["buckets"] = bucketstats.snapshot(msg.Param1)
+		if ctx.config.ClientStats {
+			stats["clients"] = clientstats.snapshot("")
+		}
 		jsbytes, err = json.Marshal(stats)
 		assert(err == nil, err)
 	default:
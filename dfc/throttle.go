@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Throttling of the background jobs (eviction, mirror restoration, erasure-code rebuild):
+// prior to each file-level operation the job calls throttle(), which sleeps
+// in proportion to the utilization of the mountpath's disk (as per /proc/diskstats)
+// and to the foreground GET latency (time to the first byte of the cache hits),
+// and enforces the configured max rate.
+
+const (
+	procDiskstatsPath = "/proc/diskstats"
+	diskstatsInterval = time.Second           // min interval between /proc/diskstats samples
+	fglatencyWeight   = 8                     // EWMA: new = old + (sample - old) / weight
+	throttleSleepMax  = 10 * time.Millisecond // defaults
+	throttleUtilLow   = 60
+	throttleUtilHigh  = 90
+)
+
+type devkey struct {
+	major, minor uint64
+}
+
+type throttler struct {
+	sync.Mutex
+	ioticks   map[devkey]uint64 // ms spent doing I/O, as of the last sample
+	util      map[devkey]int    // percent
+	sampled   time.Time
+	devs      map[string]devkey // mountpath => device
+	next      time.Time         // max rate: the next operation is allowed at
+	fglatency int64             // EWMA of the cache hit TTFB, ns
+}
+
+var throttl = &throttler{}
+
+func init() {
+	storreg.counter("throttlems") // total delay of the background jobs
+}
+
+// records the latency of a foreground local read
+func (t *throttler) observe(latency time.Duration) {
+	for {
+		old := atomic.LoadInt64(&t.fglatency)
+		n := old + (int64(latency)-old)/fglatencyWeight
+		if atomic.CompareAndSwapInt64(&t.fglatency, old, n) {
+			return
+		}
+	}
+}
+
+// to be called by background jobs prior to each file-level operation on a given mountpath
+func throttle(mpath string) {
+	d := throttl.delay(mpath)
+	if d <= 0 {
+		return
+	}
+	time.Sleep(d)
+	getstorstats().add("throttlems", int64(d/time.Millisecond))
+}
+
+func (t *throttler) delay(mpath string) (d time.Duration) {
+	conf := &ctx.config.Throttle
+	sleepmax := conf.SleepMax
+	if sleepmax == 0 {
+		sleepmax = throttleSleepMax
+	}
+	// 1. pressure: the greater of the disk utilization and foreground latency factors, 0 to 1
+	var pressure float64
+	util := t.diskutil(mpath)
+	low, high := conf.DiskUtilLow, conf.DiskUtilHigh
+	if low == 0 && high == 0 {
+		low, high = throttleUtilLow, throttleUtilHigh
+	}
+	if util > low {
+		pressure = 1
+		if high > low {
+			pressure = minf(1, float64(util-low)/float64(high-low))
+		}
+	}
+	if conf.LatencyHigh > 0 {
+		lat := atomic.LoadInt64(&t.fglatency)
+		pressure = maxf(pressure, minf(1, float64(lat)/float64(conf.LatencyHigh)))
+	}
+	d = time.Duration(pressure * float64(sleepmax))
+
+	// 2. max rate, across all background jobs
+	if conf.MaxOpsPerSec > 0 {
+		interval := time.Second / time.Duration(conf.MaxOpsPerSec)
+		t.Lock()
+		now := time.Now()
+		if t.next.Before(now) {
+			t.next = now
+		}
+		if wait := t.next.Sub(now); wait > d {
+			d = wait
+		}
+		t.next = t.next.Add(interval)
+		t.Unlock()
+	}
+	return
+}
+
+// utilization (percent) of the disk that hosts a given mountpath; 0 if unknown
+func (t *throttler) diskutil(mpath string) int {
+	t.Lock()
+	defer t.Unlock()
+	if t.devs == nil {
+		t.devs = make(map[string]devkey)
+	}
+	dev, ok := t.devs[mpath]
+	if !ok {
+		var st syscall.Stat_t
+		if err := syscall.Stat(mpath, &st); err == nil {
+			d := uint64(st.Dev)
+			dev = devkey{major: (d>>8)&0xfff | (d>>32)&^0xfff, minor: d&0xff | (d>>12)&^0xff}
+		}
+		t.devs[mpath] = dev
+	}
+	if time.Since(t.sampled) >= diskstatsInterval {
+		t.sample()
+	}
+	return t.util[dev]
+}
+
+// NOTE: the caller must hold the lock
+func (t *throttler) sample() {
+	content, err := ioutil.ReadFile(procDiskstatsPath)
+	if err != nil {
+		glog.Errorf("Failed to read %s, err: %v", procDiskstatsPath, err)
+		t.sampled = time.Now()
+		return
+	}
+	now := time.Now()
+	elapsed := now.Sub(t.sampled)
+	ioticks := parsediskstats(string(content))
+	util := make(map[devkey]int, len(ioticks))
+	if t.ioticks != nil && elapsed > 0 {
+		for dev, ticks := range ioticks {
+			if prev, ok := t.ioticks[dev]; ok && ticks >= prev {
+				u := int(time.Duration(ticks-prev) * time.Millisecond * 100 / elapsed)
+				if u > 100 {
+					u = 100
+				}
+				util[dev] = u
+			}
+		}
+	}
+	t.ioticks, t.util, t.sampled = ioticks, util, now
+}
+
+// returns the time spent doing I/Os (ms) for each device listed in /proc/diskstats:
+// major minor name reads ... io_ticks (the 10th statistics field) ...
+func parsediskstats(content string) map[devkey]uint64 {
+	out := make(map[devkey]uint64)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 13 {
+			continue
+		}
+		major, err1 := strconv.ParseUint(fields[0], 10, 64)
+		minor, err2 := strconv.ParseUint(fields[1], 10, 64)
+		ticks, err3 := strconv.ParseUint(fields[12], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		out[devkey{major, minor}] = ticks
+	}
+	return out
+}
+
+func minf(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxf(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Throttling of the background jobs: /proc/diskstats parsing, latency and rate-based delays,
+// the foreground latency of the cache hits.
+//
+// Example run:
+// 	go test -v -run=throttle
+//
+package dfc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_throttle(t *testing.T) {
+	const diskstats = `   8       0 sda 4425 1042 310422 2548 3127 4331 97256 8012 0 5084 10560 0 0 0 0
+   8       1 sda1 4355 1042 306958 2500 3127 4331 97256 8012 0 5040 10512
+ 259       0 nvme0n1 100 0 800 10
+`
+	ticks := parsediskstats(diskstats)
+	if len(ticks) != 2 || ticks[devkey{8, 0}] != 5084 || ticks[devkey{8, 1}] != 5040 {
+		t.Fatalf("Unexpected diskstats %+v", ticks)
+	}
+
+	saved := ctx.config.Throttle
+	defer func() { ctx.config.Throttle = saved }()
+	mpath := "/nonexistent/throttle/test"
+
+	// latency: full pressure at and above latency_high
+	th := &throttler{}
+	ctx.config.Throttle = throttleconfig{DiskUtilLow: 100, DiskUtilHigh: 100, LatencyHigh: time.Millisecond, SleepMax: 4 * time.Millisecond}
+	if d := th.delay(mpath); d != 0 {
+		t.Errorf("Expected no delay, got %v", d)
+	}
+	for i := 0; i < 100; i++ {
+		th.observe(10 * time.Millisecond)
+	}
+	if d := th.delay(mpath); d != 4*time.Millisecond {
+		t.Errorf("Expected max delay, got %v", d)
+	}
+
+	// max rate: 100 ops/sec => 10ms apart
+	th = &throttler{}
+	ctx.config.Throttle = throttleconfig{DiskUtilLow: 100, DiskUtilHigh: 100, MaxOpsPerSec: 100}
+	var total time.Duration
+	for i := 0; i < 5; i++ {
+		total += th.delay(mpath)
+	}
+	if total < 90*time.Millisecond || total > 110*time.Millisecond {
+		t.Errorf("Expected ~100ms total delay, got %v", total)
+	}
+}
+
+// only the cache hits contribute to the foreground latency
+func Test_throttlecoldget(t *testing.T) {
+	savedmp, savedbmd, savedrg, savedprovider := ctx.mountpaths, ctx.bmd, ctx.rg, ctx.config.CloudProvider
+	savedlatency := atomic.LoadInt64(&throttl.fglatency)
+	defer func() {
+		ctx.mountpaths, ctx.bmd, ctx.rg, ctx.config.CloudProvider = savedmp, savedbmd, savedrg, savedprovider
+		atomic.StoreInt64(&throttl.fglatency, savedlatency)
+	}()
+	mpath, _ := quotasetup(t, 0)
+	defer os.RemoveAll(mpath)
+	ctx.config.CloudProvider = amazoncloud
+	atomic.StoreInt64(&throttl.fglatency, 0)
+
+	tr := &targetrunner{cloudifs: map[string]cinterface{amazoncloud: &quotacloud{size: 10}}}
+	tr.statsif = newstatsregistry()
+	get := func() {
+		w := httptest.NewRecorder()
+		tr.httpfilget(w, httptest.NewRequest(http.MethodGet, "/"+Rversion+"/"+Rfiles+"/bucket/obj", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET failed: status %d", w.Code)
+		}
+	}
+	get()
+	if latency := atomic.LoadInt64(&throttl.fglatency); latency != 0 {
+		t.Errorf("Expected the cold GET not to be observed, got %v", time.Duration(latency))
+	}
+	get()
+	if latency := atomic.LoadInt64(&throttl.fglatency); latency == 0 {
+		t.Errorf("Expected the cache hit to be observed")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// HTTPS: with TLS.Enabled all daemons listen on HTTPS and advertise https:// URLs (ServerInfo.DirectURL),
+// so that the proxy's redirects and all intra-cluster calls keep the scheme. With TLS.Mutual
+// the daemons present their certificates, and the listeners verify the client certificates,
+// if given, against TLS.CA; the intra-cluster routes (see intracluster) require one - the
+// client-facing ones do not.
+
+func scheme() string {
+	if ctx.config.TLS.Enabled {
+		return "https"
+	}
+	return "http"
+}
+
+// the target's URL for the client's request
+func targeturl(si *ServerInfo, r *http.Request) string {
+	rawquery := r.URL.RawQuery
+	if query := r.URL.Query(); query.Get(authquery) != "" { // replaced with the redirect token
+		query.Del(authquery)
+		rawquery = query.Encode()
+	}
+	url := si.DirectURL + r.URL.Path
+	if rawquery != "" {
+		url += "?" + rawquery
+	}
+	return withtoken(url, redirecttoken(r))
+}
+
+func loadca(cafile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(cafile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No CA certificates in %q", cafile)
+	}
+	return pool, nil
+}
+
+func servertlsconfig() (*tls.Config, error) {
+	config := &ctx.config.TLS
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.Mutual {
+		pool, err := loadca(config.CA)
+		if err != nil {
+			return nil, err
+		}
+		tc.ClientCAs, tc.ClientAuth = pool, tls.VerifyClientCertIfGiven
+	}
+	return tc, nil
+}
+
+// the routes used by the daemons only: the target's control and slices, and the
+// registration with the proxy
+func intracluster(r *http.Request) bool {
+	apitems := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(apitems) < 2 || apitems[0] != Rversion {
+		return false
+	}
+	switch apitems[1] {
+	case Rdaemon, Rslices:
+		return true
+	case Rcluster: // register, unregister
+		return r.Method == http.MethodPost || (r.Method == http.MethodDelete && len(apitems) > 2 && apitems[2] == Rdaemon)
+	}
+	return false
+}
+
+// with TLS.Mutual, requires a verified client certificate for the intra-cluster routes, see registerhdlr
+func (r *httprunner) tlshdlr(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if ctx.config.TLS.Enabled && ctx.config.TLS.Mutual && intracluster(req) &&
+			(req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+			s := errmsgRestApi("Intra-cluster request requires a verified client certificate", req)
+			glog.Errorln(s)
+			http.Error(w, s, http.StatusForbidden)
+			r.statsif.add("numerr", 1)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+func clienttlsconfig() (*tls.Config, error) {
+	config := &ctx.config.TLS
+	tc := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: config.SkipVerify}
+	if config.CA != "" {
+		pool, err := loadca(config.CA)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = pool
+	}
+	if config.Mutual {
+		cert, err := tls.LoadX509KeyPair(config.Certificate, config.Key)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// intra-cluster http client (timeout 0: none)
+func newhttpclient(timeout time.Duration) (*http.Client, error) {
+	transport := &http.Transport{MaxIdleConnsPerHost: maxidleconns}
+	if ctx.config.TLS.Enabled {
+		tc, err := clienttlsconfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tc
+	}
+	return &http.Client{Transport: &authtransport{base: transport}, Timeout: timeout}, nil
+}
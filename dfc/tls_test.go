@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// TLS: HTTPS with a test CA, mutual TLS between daemons (intra-cluster routes only), redirect URLs.
+//
+// Example run:
+// 	go test -v -run=tls
+//
+package dfc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writes a CA and a certificate signed by it for 127.0.0.1
+func testcerts(t *testing.T, dir string) (ca, cert, key string) {
+	writepem := func(name, typ string, der []byte) string {
+		fname := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fname, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return fname
+	}
+	cakey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dfc test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	cader, err := x509.CreateCertificate(rand.Reader, catmpl, catmpl, &cakey.PublicKey, cakey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafkey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaftmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "dfc"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	leafder, err := x509.CreateCertificate(rand.Reader, leaftmpl, catmpl, &leafkey.PublicKey, cakey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(leafkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writepem("ca.pem", "CERTIFICATE", cader), writepem("cert.pem", "CERTIFICATE", leafder),
+		writepem("key.pem", "EC PRIVATE KEY", keyder)
+}
+
+func Test_tls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := ctx.config.TLS
+	defer func() { ctx.config.TLS = saved }()
+	ca, cert, key := testcerts(t, dir)
+
+	hr := &httprunner{statsif: newstatsregistry()}
+	get := func(mutual, clientcert bool, path string) (int, error) {
+		ctx.config.TLS = tlsconfig{Enabled: true, Certificate: cert, Key: key, CA: ca, Mutual: mutual}
+		servertc, err := servertlsconfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		servertc.Certificates = []tls.Certificate{pair}
+		server := httptest.NewUnstartedServer(http.HandlerFunc(hr.tlshdlr(func(w http.ResponseWriter, r *http.Request) {})))
+		server.TLS = servertc
+		server.StartTLS()
+		defer server.Close()
+
+		ctx.config.TLS.Mutual = clientcert
+		client, err := newhttpclient(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx.config.TLS.Mutual = mutual // the server's
+		response, err := client.Get(server.URL + path)
+		if err != nil {
+			return 0, err
+		}
+		response.Body.Close()
+		return response.StatusCode, nil
+	}
+	daemonpath, filespath := "/"+Rversion+"/"+Rdaemon, "/"+Rversion+"/"+Rfiles+"/bucket/obj"
+	if status, err := get(false, false, daemonpath); err != nil || status != http.StatusOK {
+		t.Errorf("HTTPS GET failed: %d, err: %v", status, err)
+	}
+	if status, err := get(true, true, daemonpath); err != nil || status != http.StatusOK {
+		t.Errorf("Mutual TLS GET failed: %d, err: %v", status, err)
+	}
+	if status, err := get(true, false, filespath); err != nil || status != http.StatusOK {
+		t.Errorf("Expected mutual TLS to accept a client without a certificate: %d, err: %v", status, err)
+	}
+	if status, err := get(true, false, daemonpath); err != nil || status != http.StatusForbidden {
+		t.Errorf("Expected mutual TLS to reject an intra-cluster request without a certificate: %d, err: %v", status, err)
+	}
+	for _, c := range []struct {
+		method, path string
+		expected     bool
+	}{
+		{http.MethodGet, "/" + Rversion + "/" + Rslices + "/bucket/obj", true},
+		{http.MethodPost, "/" + Rversion + "/" + Rcluster, true},
+		{http.MethodDelete, "/" + Rversion + "/" + Rcluster + "/" + Rdaemon + "/t1", true},
+		{http.MethodGet, "/" + Rversion + "/" + Rcluster, false},
+		{http.MethodPut, "/" + Rversion + "/" + Rfiles + "/bucket", false},
+	} {
+		if intracluster(httptest.NewRequest(c.method, c.path, nil)) != c.expected {
+			t.Errorf("%s %s: expected intra-cluster %v", c.method, c.path, c.expected)
+		}
+	}
+
+	if scheme() != "https" {
+		t.Errorf("Unexpected scheme %q", scheme())
+	}
+	si := &ServerInfo{DirectURL: "https://127.0.0.1:8081"}
+	r := httptest.NewRequest(http.MethodGet, "https://127.0.0.1:8080/v1/files/bucket/obj?x=1", nil)
+	if url := targeturl(si, r); url != "https://127.0.0.1:8081/v1/files/bucket/obj?x=1" {
+		t.Errorf("Unexpected redirect URL %q", url)
+	}
+}
@@ -13,10 +13,15 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
+	"syscall"
 
+	"github.com/OneOfOne/xxhash"
 	"github.com/golang/glog"
 )
 
+const xattrXXHash = "user.dfc.xxhash"
+
 func assert(cond bool, args ...interface{}) {
 	if cond {
 		return
@@ -147,6 +152,46 @@ func copyBuffer(dst io.Writer, src io.Reader) (written int64, err error) {
 	return written, err
 }
 
+//===========================================================================
+//
+// object checksums (see BucketProps.Checksum), stored as extended attributes
+//
+//===========================================================================
+func computexxhash(fqn string) (string, error) {
+	file, err := os.Open(fqn)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := xxhash.New64()
+	if _, err = copyBuffer(h, file); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(h.Sum64(), 16), nil
+}
+
+func setxxhash(fqn string) error {
+	cksum, err := computexxhash(fqn)
+	if err != nil {
+		return err
+	}
+	return syscall.Setxattr(fqn, xattrXXHash, []byte(cksum), 0)
+}
+
+// objects with no stored checksum are considered valid
+func checkxxhash(fqn string) (bool, error) {
+	buf := make([]byte, 32)
+	n, err := syscall.Getxattr(fqn, xattrXXHash, buf)
+	if err != nil {
+		return true, nil
+	}
+	cksum, err := computexxhash(fqn)
+	if err != nil {
+		return false, err
+	}
+	return cksum == string(buf[:n]), nil
+}
+
 //===========================================================================
 //
 // dummy io.Writer & ReadToNull() helper
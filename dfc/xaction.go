@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+package dfc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Extended actions (xactions): long-running background jobs - eviction, mirror restoration,
+// erasure-code rebuild, etc. on the targets, and cluster-wide operations (e.g., syncsmap)
+// on the proxy. Each xaction is registered with a unique (per daemon) ID and its kind,
+// keeps progress counters, and can be aborted via its context. At most one xaction of
+// a given kind runs at any point in time, except for the kinds started via startmulti
+// (e.g., prefetch: one xaction per request). The proxy reports its own xactions along with
+// all targets' (GET xactions /v1/cluster).
+
+// xaction kinds
+const (
+	XactLRU        = "lru"            // see lrujob.go
+	XactMirror     = "restoremirrors" // see mirror.go
+	XactECRebuild  = "ecrebuild"      // see ec.go
+	XactExpiry     = "expiry"         // see expiry.go
+	XactPrefetch   = "prefetch"       // see prefetch.go
+	XactSyncSmap   = "syncsmap"       // proxy: distributing the cluster map, see proxy.go
+	xactkeepfinish = 32               // number of finished xactions to keep for the status queries
+)
+
+// GET '{"what": "xactions"}' /v1/daemon (or /v1/cluster)
+type XactStatus struct {
+	ID       int64            `json:"id"`
+	Kind     string           `json:"kind"`
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"` // zero: still running
+	Aborted  bool             `json:"aborted"`
+	Progress map[string]int64 `json:"progress"`
+	Detail   interface{}      `json:"detail,omitempty"` // kind-specific, e.g. LRUStatus
+}
+
+type xaction struct {
+	sync.Mutex
+	status   XactStatus
+	ctx      context.Context
+	cancel   context.CancelFunc
+	detailfn func() interface{} // optional
+}
+
+type xactions struct {
+	sync.Mutex
+	lastid   int64
+	running  map[int64]*xaction // by ID
+	finished []*xaction
+}
+
+var (
+	xactreg        = newxactions()
+	errXactRunning = errors.New("already running")
+	errXactAborted = errors.New("aborted")
+)
+
+func newxactions() *xactions {
+	return &xactions{running: make(map[int64]*xaction)}
+}
+
+// registers and returns a new xaction unless one of the same kind is already running
+func (r *xactions) start(kind string) (*xaction, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.isrunningl(kind) {
+		return nil, fmt.Errorf("%s is %v", kind, errXactRunning)
+	}
+	return r.registerl(kind), nil
+}
+
+// registers and returns a new xaction that runs alongside the others of the same kind
+func (r *xactions) startmulti(kind string) *xaction {
+	r.Lock()
+	defer r.Unlock()
+	return r.registerl(kind)
+}
+
+func (r *xactions) registerl(kind string) *xaction {
+	r.lastid++
+	x := &xaction{status: XactStatus{ID: r.lastid, Kind: kind, Started: time.Now(), Progress: make(map[string]int64)}}
+	x.ctx, x.cancel = context.WithCancel(context.Background())
+	r.running[x.status.ID] = x
+	glog.Infof("xaction %s[%d] started", kind, x.status.ID)
+	return x
+}
+
+func (r *xactions) finish(x *xaction) {
+	var detail interface{}
+	x.Lock()
+	detailfn := x.detailfn
+	x.Unlock()
+	if detailfn != nil {
+		detail = detailfn()
+	}
+	x.Lock()
+	x.status.Finished = time.Now()
+	x.status.Detail, x.detailfn = detail, nil // frozen
+	x.Unlock()
+	x.cancel() // release the context
+	r.Lock()
+	delete(r.running, x.status.ID)
+	r.finished = append(r.finished, x)
+	if len(r.finished) > xactkeepfinish {
+		r.finished = r.finished[len(r.finished)-xactkeepfinish:]
+	}
+	r.Unlock()
+	glog.Infof("xaction %s[%d] finished", x.status.Kind, x.status.ID)
+}
+
+// aborts the running xaction identified by its ID, or all running xactions of the kind
+func (r *xactions) abort(idorkind string) error {
+	r.Lock()
+	defer r.Unlock()
+	id, _ := strconv.ParseInt(idorkind, 10, 64)
+	found := false
+	for _, x := range r.running {
+		if x.status.Kind == idorkind || x.status.ID == id {
+			x.abort()
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("xaction %q is not running", idorkind)
+	}
+	return nil
+}
+
+// running and recently finished xactions, ordered by ID
+func (r *xactions) list() []*XactStatus {
+	r.Lock()
+	xacts := make([]*xaction, 0, len(r.running)+len(r.finished))
+	for _, x := range r.running {
+		xacts = append(xacts, x)
+	}
+	xacts = append(xacts, r.finished...)
+	r.Unlock()
+	out := make([]*XactStatus, len(xacts))
+	for i, x := range xacts {
+		out[i] = x.getstatus()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (r *xactions) isrunning(kind string) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.isrunningl(kind)
+}
+
+func (r *xactions) isrunningl(kind string) bool {
+	for _, x := range r.running {
+		if x.status.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+//===========================
+//
+// xaction
+//
+//===========================
+func (x *xaction) add(counter string, n int64) {
+	x.Lock()
+	x.status.Progress[counter] += n
+	x.Unlock()
+}
+
+func (x *xaction) abort() {
+	x.Lock()
+	x.status.Aborted = true
+	x.Unlock()
+	x.cancel()
+	glog.Infof("xaction %s[%d] aborted", x.status.Kind, x.status.ID)
+}
+
+func (x *xaction) aborted() bool {
+	select {
+	case <-x.ctx.Done():
+		x.Lock()
+		defer x.Unlock()
+		return x.status.Finished.IsZero()
+	default:
+		return false
+	}
+}
+
+func (x *xaction) getstatus() *XactStatus {
+	x.Lock()
+	status := x.status
+	status.Progress = make(map[string]int64, len(x.status.Progress))
+	for k, v := range x.status.Progress {
+		status.Progress[k] = v
+	}
+	detailfn := x.detailfn
+	x.Unlock()
+	if detailfn != nil {
+		status.Detail = detailfn()
+	}
+	return &status
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2017, NVIDIA CORPORATION. All rights reserved.
+ *
+ */
+
+// Xaction registry: one running xaction per kind (except for startmulti), abort by kind and by ID, status history,
+// the cluster view of the proxy's and the targets' xactions, including the targets that fail.
+//
+// Example run:
+// 	go test -v -run=xaction
+//
+package dfc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_xaction(t *testing.T) {
+	reg := newxactions()
+	x, err := reg.start(XactMirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reg.start(XactMirror); err == nil {
+		t.Fatal("Expected an error starting a second xaction of the same kind")
+	}
+	y, err := reg.start(XactECRebuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.add("filesscanned", 10)
+	x.add("filesscanned", 5)
+	if err = reg.abort(XactMirror); err != nil {
+		t.Fatal(err)
+	}
+	if !x.aborted() || y.aborted() {
+		t.Fatal("Expected only the first xaction to be aborted")
+	}
+	if err = reg.abort(strconv.FormatInt(y.status.ID, 10)); err != nil || !y.aborted() {
+		t.Fatalf("Failed to abort xaction by ID, err: %v", err)
+	}
+	reg.finish(x)
+	reg.finish(y)
+	if reg.isrunning(XactMirror) || reg.abort(XactMirror) == nil {
+		t.Error("Expected no running xactions")
+	}
+	list := reg.list()
+	if len(list) != 2 || list[0].ID != x.status.ID || list[0].Progress["filesscanned"] != 15 ||
+		!list[0].Aborted || list[0].Finished.IsZero() {
+		t.Errorf("Unexpected xaction status %+v", list)
+	}
+}
+
+func Test_xactmulti(t *testing.T) {
+	reg := newxactions()
+	x, y := reg.startmulti(XactPrefetch), reg.startmulti(XactPrefetch)
+	if x.status.ID == y.status.ID || !reg.isrunning(XactPrefetch) {
+		t.Fatalf("Expected two running %s xactions", XactPrefetch)
+	}
+	if _, err := reg.start(XactPrefetch); err == nil {
+		t.Error("Expected an error starting an exclusive xaction of the running kind")
+	}
+	reg.finish(x)
+	if !reg.isrunning(XactPrefetch) {
+		t.Fatal("Expected the second xaction to keep running")
+	}
+	z := reg.startmulti(XactPrefetch)
+	if err := reg.abort(XactPrefetch); err != nil || !y.aborted() || !z.aborted() {
+		t.Fatalf("Expected all running %s xactions to be aborted, err: %v", XactPrefetch, err)
+	}
+	reg.finish(y)
+	reg.finish(z)
+	if reg.isrunning(XactPrefetch) || len(reg.list()) != 3 {
+		t.Errorf("Unexpected xactions %+v", reg.list())
+	}
+}
+
+func Test_xactcluster(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 1, "kind": "lru"}]`))
+	}))
+	defer target.Close()
+	hung := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hung }))
+	defer slow.Close()
+	defer close(hung)
+	savedsmap, savedtimeout := ctx.smap, ctx.config.Proxy.StatsTimeout
+	defer func() { ctx.smap, ctx.config.Proxy.StatsTimeout = savedsmap, savedtimeout }()
+	ctx.smap = &Smap{Smap: map[string]*ServerInfo{
+		"t1":   {DaemonID: "t1", DirectURL: target.URL},
+		"slow": {DaemonID: "slow", DirectURL: slow.URL},
+	}}
+	ctx.config.Proxy.StatsTimeout = 100 * time.Millisecond
+
+	x, err := xactreg.start(XactSyncSmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xactreg.finish(x)
+	p := &proxyrunner{}
+	p.httpclient = &http.Client{}
+	p.statsif = newstatsregistry()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/"+Rversion+"/"+Rcluster, strings.NewReader(`{"what": "xactions"}`))
+	p.httpcluget(w, r)
+	var out struct {
+		Proxy   []*XactStatus            `json:"proxy"`
+		Targets map[string][]*XactStatus `json:"targets"`
+		Errors  map[string]string        `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%v [%s]", err, w.Body.String())
+	}
+	var found bool
+	for _, status := range out.Proxy {
+		found = found || (status.ID == x.status.ID && status.Kind == XactSyncSmap && status.Finished.IsZero())
+	}
+	if !found {
+		t.Errorf("Expected the proxy's running %s, got %+v", XactSyncSmap, out.Proxy)
+	}
+	if xacts := out.Targets["t1"]; len(xacts) != 1 || xacts[0].Kind != XactLRU {
+		t.Errorf("Unexpected target xactions %+v", out.Targets)
+	}
+	// the target that did not respond in time is reported rather than dropped
+	if len(out.Errors) != 1 || out.Errors["slow"] == "" {
+		t.Errorf("Unexpected per-target errors %+v", out.Errors)
+	}
+}